@@ -0,0 +1,502 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/joeyak/scrum-poker/models"
+)
+
+type apiError struct {
+	Error string
+}
+
+type apiSession struct {
+	models.SessionInfo
+	ID      string
+	Expires time.Time
+	Showing bool
+	Locked  bool
+	// HasPasscode is if a passcode is needed to join
+	HasPasscode bool
+	// CoffeeBreak is if enough participants picked ☕ to suggest a break
+	CoffeeBreak bool
+	// Timer is the countdown of the current round, if one was started
+	Timer *models.Timer `json:",omitempty"`
+	// RevealsAt is when the results are shown automatically now that everyone has voted
+	RevealsAt   *time.Time `json:",omitempty"`
+	Stories     []models.Story
+	Users       []apiUser
+	Results     []exportRow `json:",omitempty"`
+	Final       *float64    `json:",omitempty"`
+	Range       string      `json:",omitempty"`
+	Recommended string      `json:",omitempty"`
+}
+
+type apiUser struct {
+	models.UserInfo
+	ID          string
+	Active      bool
+	Ready       bool
+	Facilitator bool
+	// Cards are only shown once the results are showing
+	Cards map[string]string `json:",omitempty"`
+}
+
+type apiSessionCreate struct {
+	models.SessionInfo
+	Passcode string
+}
+
+// apiSessionCreated has the creator key so the api client can become the facilitator when it joins
+type apiSessionCreated struct {
+	apiSession
+	CreatorKey string
+}
+
+type apiUserJoin struct {
+	models.UserInfo
+	Passcode string
+	// CreatorKey makes the user the facilitator if it's the key given when the session was created
+	CreatorKey string
+}
+
+type apiJoin struct {
+	User  apiUser
+	Token string
+}
+
+type apiVote struct {
+	Row, Card string
+}
+
+type apiFacilitator struct {
+	UserID string
+}
+
+// apiTimer starts a timer for the seconds, or the session's timer duration if it's zero
+type apiTimer struct {
+	Seconds int
+}
+
+// apiSettings only changes the settings that are set
+type apiSettings struct {
+	OpenControls *bool
+	Locked       *bool
+}
+
+func newApiSession(session *models.Session) apiSession {
+	results := session.Calc()
+	snapshot := session.Snapshot()
+
+	data := apiSession{
+		SessionInfo: snapshot.SessionInfo,
+		ID:          snapshot.ID,
+		Expires:     snapshot.Expires,
+		Showing:     snapshot.Showing,
+		Locked:      snapshot.Locked,
+		HasPasscode: snapshot.Passcode != nil,
+		CoffeeBreak: snapshot.CoffeeBreak(),
+		Timer:       snapshot.Timer,
+		Stories:     snapshot.Stories,
+		Users:       []apiUser{},
+	}
+	if data.Stories == nil {
+		data.Stories = []models.Story{}
+	}
+	if revealsAt, ok := snapshot.RevealsAt(); ok {
+		data.RevealsAt = &revealsAt
+	}
+
+	for _, user := range snapshot.ReadyUsers() {
+		data.Users = append(data.Users, newApiUser(user.User, user.Ready, snapshot.Showing))
+	}
+
+	for _, result := range results {
+		data.Results = append(data.Results, newExportRow(result))
+	}
+
+	if len(results) > 0 {
+		final := snapshot.Final(results[0])
+		data.Final = &final
+		data.Range = snapshot.FinalRange(final)
+		data.Recommended = snapshot.Recommended(results[0])
+	}
+
+	return data
+}
+
+func newApiUser(user models.User, ready, showing bool) apiUser {
+	data := apiUser{
+		UserInfo:    user.UserInfo,
+		ID:          user.ID,
+		Active:      user.Active,
+		Ready:       ready,
+		Facilitator: user.Facilitator,
+	}
+	if showing {
+		data.Cards = user.Cards
+	}
+	return data
+}
+
+func writeApiJson(w http.ResponseWriter, status int, value any) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		slog.Error("could not marshal api response", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func writeApiError(w http.ResponseWriter, status int, message string) {
+	writeApiJson(w, status, apiError{Error: message})
+}
+
+// writeApiCommandError maps errors from Session.Apply to a status code
+func writeApiCommandError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	switch {
+	case errors.Is(err, models.ErrUnknownUser):
+		status = http.StatusUnauthorized
+	case errors.Is(err, models.ErrNotFacilitator):
+		status = http.StatusForbidden
+	case errors.Is(err, models.ErrWrongPasscode):
+		status = http.StatusUnauthorized
+	case errors.Is(err, models.ErrLocked):
+		status = http.StatusLocked
+	case errors.Is(err, models.ErrShowing), errors.Is(err, models.ErrCardsMissing):
+		status = http.StatusConflict
+	}
+	writeApiError(w, status, err.Error())
+}
+
+func apiGetSession(w http.ResponseWriter, r *http.Request) *models.Session {
+	session := sessionManager.Get(r.PathValue("sessionID"))
+	if session == nil {
+		writeApiError(w, http.StatusNotFound, "session not found")
+	}
+	return session
+}
+
+// apiGetUser gets the user from the bearer token, which is the signed token given when joining
+func apiGetUser(w http.ResponseWriter, r *http.Request, session *models.Session) (models.User, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		writeApiError(w, http.StatusUnauthorized, "missing bearer token")
+		return models.User{}, false
+	}
+
+	userID, ok := verifyValue(apiTokenName, token)
+	if !ok {
+		writeApiError(w, http.StatusUnauthorized, "invalid bearer token")
+		return models.User{}, false
+	}
+
+	user, ok := session.User(userID)
+	if !ok {
+		writeApiError(w, http.StatusUnauthorized, "invalid bearer token")
+	}
+	return user, ok
+}
+
+func decodeApiBody(w http.ResponseWriter, r *http.Request, value any) bool {
+	err := json.NewDecoder(r.Body).Decode(value)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, "invalid json body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func handleApiNotFound(w http.ResponseWriter, r *http.Request) {
+	writeApiError(w, http.StatusNotFound, "not found")
+}
+
+func handleApiSessionCreate(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requestIdentity(r); !ok && oidc != nil && oidc.requiredToCreate {
+		writeApiError(w, http.StatusUnauthorized, "logging in is needed to create sessions")
+		return
+	}
+
+	body := apiSessionCreate{SessionInfo: models.SessionInfo{MapToScale: true}}
+	if !decodeApiBody(w, r, &body) {
+		return
+	}
+	if body.Deck == "" && len(body.Cards) == 0 {
+		body.Deck = defaultDeck
+	}
+
+	info := models.NewSessionInfo(body.Cards, body.Rows, body.Groups, body.MapToScale)
+	info.GroupRule = body.GroupRule
+	info.Scale = body.Scale
+	info.Rounding = body.Rounding
+	info.Aggregation = body.Aggregation
+	info.OpenControls = body.OpenControls
+	info.CardValues = body.CardValues
+	info.Sizes = body.Sizes
+	info.SpecialCards = body.SpecialCards
+	info.CoffeeBreakVotes = body.CoffeeBreakVotes
+	info.TimerSeconds = body.TimerSeconds
+	info.TimerAutoReveal = body.TimerAutoReveal
+	info.AutoReveal = body.AutoReveal
+	info.AutoRevealSeconds = body.AutoRevealSeconds
+	err := info.UseDeck(body.Deck)
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if body.Deck != "" {
+		info.SetCardValues(body.CardValues)
+	}
+	info.RowDecks = body.RowDecks
+	err = info.ResolveRowDecks()
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = info.Validate()
+	if err != nil {
+		writeApiError(w, http.StatusBadRequest, "invalid session settings: "+err.Error())
+		return
+	}
+
+	session, err := sessionManager.New(info, body.Passcode)
+	if err != nil {
+		slog.Error("could not create session through api", "err", err)
+		writeApiError(w, http.StatusInternalServerError, "could not create session")
+		return
+	}
+	slog.Info("session created through api", "session", session.ID)
+	writeApiJson(w, http.StatusCreated, apiSessionCreated{
+		apiSession: newApiSession(session),
+		CreatorKey: session.CreatorKey,
+	})
+}
+
+func handleApiSession(w http.ResponseWriter, r *http.Request) {
+	session := apiGetSession(w, r)
+	if session == nil {
+		return
+	}
+	if status := sessionAccess(r, session); status != http.StatusOK {
+		writeApiError(w, status, "a passcode, session cookie or bearer token of one of the session's users is needed")
+		return
+	}
+
+	writeApiJson(w, http.StatusOK, newApiSession(session))
+}
+
+func handleApiUserJoin(w http.ResponseWriter, r *http.Request) {
+	session := apiGetSession(w, r)
+	if session == nil {
+		return
+	}
+
+	info := apiUserJoin{UserInfo: models.UserInfo{Type: models.UserTypeParticipant}}
+	if !decodeApiBody(w, r, &info) {
+		return
+	}
+
+	if strings.TrimSpace(info.Name) == "" {
+		writeApiError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if info.Type != models.UserTypeParticipant && info.Type != models.UserTypeWatcher {
+		writeApiError(w, http.StatusBadRequest, "type must be Participant or Watcher")
+		return
+	}
+	if info.CreatorKey != "" && !session.CheckCreatorKey(info.CreatorKey) {
+		writeApiError(w, http.StatusForbidden, "wrong creator key")
+		return
+	}
+
+	user, err := session.NewUser(info.Name, info.Type, info.Group, info.Passcode)
+	if err != nil {
+		writeApiCommandError(w, err)
+		return
+	}
+	slog.Info("user joined through api", "session", session.ID, "name", user.Name, "type", user.Type, "group", user.Group)
+
+	if info.CreatorKey != "" && session.ClaimFacilitator(user.ID, info.CreatorKey) {
+		slog.Info("creator joined through api", "session", session.ID, "name", user.Name)
+	}
+
+	// Api users don't hold a connection, so they count as active until they are removed
+	session.UpdateUser(user.ID, func(user *models.User) { user.Active = true })
+	if joined, ok := session.User(user.ID); ok {
+		user = joined
+	}
+	session.SendUpdates()
+
+	writeApiJson(w, http.StatusCreated, apiJoin{
+		User:  newApiUser(user, false, false),
+		Token: signValue(apiTokenName, user.ID),
+	})
+}
+
+func handleApiUserKick(w http.ResponseWriter, r *http.Request) {
+	session := apiGetSession(w, r)
+	if session == nil {
+		return
+	}
+
+	caller, ok := apiGetUser(w, r, session)
+	if !ok {
+		return
+	}
+
+	user, ok := session.User(r.PathValue("userID"))
+	if !ok {
+		writeApiError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	_, err := session.Apply(caller.ID, models.KickUser{UserID: user.ID})
+	if err != nil {
+		writeApiCommandError(w, err)
+		return
+	}
+	session.SendUpdates()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiCommand applies the command as the bearer user and responds with the updated session
+func apiCommand(w http.ResponseWriter, r *http.Request, session *models.Session, command models.Command) {
+	user, ok := apiGetUser(w, r, session)
+	if !ok {
+		return
+	}
+
+	_, err := session.Apply(user.ID, command)
+	if err != nil {
+		writeApiCommandError(w, err)
+		return
+	}
+	session.SendUpdates()
+
+	writeApiJson(w, http.StatusOK, newApiSession(session))
+}
+
+func handleApiVote(w http.ResponseWriter, r *http.Request) {
+	session := apiGetSession(w, r)
+	if session == nil {
+		return
+	}
+
+	var vote apiVote
+	if !decodeApiBody(w, r, &vote) {
+		return
+	}
+
+	apiCommand(w, r, session, models.SelectCard{Row: vote.Row, Card: vote.Card})
+}
+
+func handleApiVoteUndo(w http.ResponseWriter, r *http.Request) {
+	session := apiGetSession(w, r)
+	if session == nil {
+		return
+	}
+
+	apiCommand(w, r, session, models.UndoCard{Row: r.URL.Query().Get("row")})
+}
+
+func handleApiReveal(w http.ResponseWriter, r *http.Request) {
+	session := apiGetSession(w, r)
+	if session == nil {
+		return
+	}
+
+	apiCommand(w, r, session, models.ShowResults{})
+}
+
+func handleApiReset(w http.ResponseWriter, r *http.Request) {
+	session := apiGetSession(w, r)
+	if session == nil {
+		return
+	}
+
+	apiCommand(w, r, session, models.ResetResults{})
+}
+
+func handleApiTimerStart(w http.ResponseWriter, r *http.Request) {
+	session := apiGetSession(w, r)
+	if session == nil {
+		return
+	}
+
+	var timer apiTimer
+	if !decodeApiBody(w, r, &timer) {
+		return
+	}
+
+	apiCommand(w, r, session, models.StartTimer{Seconds: timer.Seconds})
+}
+
+func handleApiTimerStop(w http.ResponseWriter, r *http.Request) {
+	session := apiGetSession(w, r)
+	if session == nil {
+		return
+	}
+
+	apiCommand(w, r, session, models.StopTimer{})
+}
+
+func handleApiFacilitator(w http.ResponseWriter, r *http.Request) {
+	session := apiGetSession(w, r)
+	if session == nil {
+		return
+	}
+
+	var facilitator apiFacilitator
+	if !decodeApiBody(w, r, &facilitator) {
+		return
+	}
+
+	apiCommand(w, r, session, models.TransferFacilitator{UserID: facilitator.UserID})
+}
+
+func handleApiSettings(w http.ResponseWriter, r *http.Request) {
+	session := apiGetSession(w, r)
+	if session == nil {
+		return
+	}
+
+	var settings apiSettings
+	if !decodeApiBody(w, r, &settings) {
+		return
+	}
+
+	user, ok := apiGetUser(w, r, session)
+	if !ok {
+		return
+	}
+
+	var batch models.Batch
+	if settings.OpenControls != nil {
+		batch = append(batch, models.SetOpenControls{Enabled: *settings.OpenControls})
+	}
+	if settings.Locked != nil {
+		batch = append(batch, models.SetLocked{Enabled: *settings.Locked})
+	}
+
+	// Applied as one batch so a bad setting doesn't leave the others half changed
+	_, err := session.Apply(user.ID, batch)
+	if err != nil {
+		writeApiCommandError(w, err)
+		return
+	}
+	session.SendUpdates()
+
+	writeApiJson(w, http.StatusOK, newApiSession(session))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newApiTestServer runs the site with its own session manager and secret
+func newApiTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	handler, err := newHandler(false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	oldManager, oldSecret, oldOidc := sessionManager, cookieSecret, oidc
+	sessionManager = NewSessionManager(NewMemoryStore())
+	cookieSecret = newCookieSecret()
+	oidc = nil
+	t.Cleanup(func() { sessionManager, cookieSecret, oidc = oldManager, oldSecret, oldOidc })
+
+	return server
+}
+
+// apiRequest sends the body as json with the bearer token if it isn't empty, and decodes the response into value if it isn't nil
+func apiRequest(t *testing.T, method, url, token string, body, value any) int {
+	t.Helper()
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := http.NewRequest(method, url, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if value != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(value); err != nil {
+			t.Fatalf("could not decode %s %s response: %v", method, url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestApiCreatorKey(t *testing.T) {
+	server := newApiTestServer(t)
+
+	var created apiSessionCreated
+	if status := apiRequest(t, http.MethodPost, server.URL+"/api/v1/sessions", "", map[string]any{}, &created); status != http.StatusCreated {
+		t.Fatalf("create returned %d", status)
+	}
+	if created.CreatorKey == "" {
+		t.Fatal("create did not return a creator key")
+	}
+	usersURL := server.URL + "/api/v1/sessions/" + created.ID + "/users"
+
+	var first apiJoin
+	if status := apiRequest(t, http.MethodPost, usersURL, "", map[string]any{"Name": "first"}, &first); status != http.StatusCreated {
+		t.Fatalf("join returned %d", status)
+	}
+
+	if status := apiRequest(t, http.MethodPost, usersURL, "", map[string]any{"Name": "guess", "CreatorKey": "guess"}, nil); status != http.StatusForbidden {
+		t.Errorf("join with the wrong creator key returned %d, wanted %d", status, http.StatusForbidden)
+	}
+
+	var creator apiJoin
+	if status := apiRequest(t, http.MethodPost, usersURL, "", map[string]any{"Name": "creator", "CreatorKey": created.CreatorKey}, &creator); status != http.StatusCreated {
+		t.Fatalf("join with the creator key returned %d", status)
+	}
+	if !creator.User.Facilitator {
+		t.Error("user who joined with the creator key is not the facilitator")
+	}
+
+	session := sessionManager.Get(created.ID).Snapshot()
+	if session.Users[first.User.ID].Facilitator {
+		t.Error("first user is still the facilitator")
+	}
+	if len(session.Users) != 2 {
+		t.Errorf("there are %d users, wanted the user with the wrong key left out", len(session.Users))
+	}
+}
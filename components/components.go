@@ -1,15 +1,96 @@
 package components
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"math"
+	"net/url"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/a-h/templ"
 	"github.com/joeyak/scrum-poker/models"
 )
 
-var fibonacciSequence = []float64{1, 2, 3, 5, 8, 13, 21}
+// Transport is how the session room gets its updates
+type Transport string
+
+var (
+	TransportWebSocket Transport = "ws"
+	TransportSSE       Transport = "sse"
+	// TransportAuto uses websockets and falls back to server sent events if the websocket can't connect
+	TransportAuto Transport = "auto"
+)
+
+type actionURLKey struct{}
+
+// WithActionURL makes the session room post its actions to the url instead of sending them through the websocket
+func WithActionURL(ctx context.Context, url string) context.Context {
+	return context.WithValue(ctx, actionURLKey{}, url)
+}
+
+func sendAttrs(ctx context.Context) templ.Attributes {
+	if url, ok := ctx.Value(actionURLKey{}).(string); ok {
+		return templ.Attributes{
+			"hx-post":   url,
+			"hx-ext":    "json-enc",
+			"hx-target": "#pokerError",
+			"hx-swap":   "outerHTML",
+		}
+	}
+	return templ.Attributes{"ws-send": true}
+}
+
+// Login is the OpenID Connect login of the user
+type Login struct {
+	// Enabled is if users can log in
+	Enabled bool
+	// RequiredToCreate is if users have to log in to create a session
+	RequiredToCreate bool
+	// Name is the name of the logged in user, it's empty if they aren't logged in
+	Name string
+}
+
+type loginKey struct{}
+
+func WithLogin(ctx context.Context, login Login) context.Context {
+	return context.WithValue(ctx, loginKey{}, login)
+}
+
+func loginState(ctx context.Context) Login {
+	login, _ := ctx.Value(loginKey{}).(Login)
+	return login
+}
+
+func loginLink(next string) string {
+	return "/login?next=" + url.QueryEscape(next)
+}
+
+type csrfTokenKey struct{}
+
+// WithCSRFToken adds the csrf token to the forms and htmx requests
+func WithCSRFToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, csrfTokenKey{}, token)
+}
+
+func csrfToken(ctx context.Context) string {
+	token, _ := ctx.Value(csrfTokenKey{}).(string)
+	return token
+}
+
+func csrfHeaders(ctx context.Context) string {
+	data, _ := json.Marshal(map[string]string{"X-CSRF-Token": csrfToken(ctx)})
+	return string(data)
+}
+
+// hxVals encodes the values for hx-vals so row and card labels with quotes don't break it
+func hxVals(values map[string]any) string {
+	data, _ := json.Marshal(values)
+	return string(data)
+}
 
 func userAnswer(cards map[string]string) string {
 	var answers []string
@@ -20,28 +101,129 @@ func userAnswer(cards map[string]string) string {
 	return strings.Join(answers, ", ")
 }
 
-func exitLink(session models.Session, user models.User) string {
-	return fmt.Sprintf("/session/%s/user/%s/exit", session.ID, user.ID)
+func roundVotes(votes []models.Vote) string {
+	var answers []string
+	for _, vote := range votes {
+		answers = append(answers, fmt.Sprintf("%s: %s", vote.Name, userAnswer(vote.Cards)))
+	}
+	return strings.Join(answers, " | ")
 }
 
-func trimFloat(f float64) string {
-	return strings.TrimRight(strings.TrimRight(strconv.FormatFloat(f, 'f', 2, 64), "0"), ".")
+func finalSummary(session models.Session, round models.Round) string {
+	if !session.MapsResult() {
+		return fmt.Sprintf("Days: %s", trimFloat(round.Final))
+	}
+
+	summary := fmt.Sprintf("Points: %s (%s)", trimFloat(round.Final), session.FinalRange(round.Final))
+	if recommended := session.Recommended(round.Result()); recommended != "" {
+		summary += ", Recommended: " + recommended
+	}
+	return summary
 }
 
-func finalResultRange(f float64) string {
-	last := 0.0
-	current := fibonacciSequence[0]
-	for _, seq := range fibonacciSequence[1:] {
-		last = current
-		current = seq
-		if f < seq {
-			break
+// cardValues is the card values as card=number in the order of the cards
+func cardValues(info models.SessionInfo) string {
+	var values []string
+	for _, card := range info.Cards {
+		if value, ok := info.CardValue(card); ok {
+			values = append(values, fmt.Sprintf("%s=%s", card, trimFloat(value)))
 		}
 	}
+	return strings.Join(values, ",")
+}
+
+func scaleValues(scale []float64) string {
+	var values []string
+	for _, value := range scale {
+		values = append(values, trimFloat(value))
+	}
+	return strings.Join(values, ",")
+}
+
+func roundingName(rounding models.Rounding) string {
+	switch rounding {
+	case models.RoundUp:
+		return "Always up"
+	case models.RoundDown:
+		return "Always down"
+	}
+	return "Nearest"
+}
+
+func aggregationName(aggregation models.Aggregation) string {
+	switch aggregation {
+	case models.AggregateMedian:
+		return "Median"
+	case models.AggregateMode:
+		return "Mode"
+	}
+	return "Average"
+}
 
-	if f > current {
-		return fmt.Sprintf("%s < X", trimFloat(f))
+func groupRuleName(rule models.GroupRule) string {
+	switch rule {
+	case models.GroupRuleMax:
+		return "Highest group"
+	case models.GroupRuleAverage:
+		return "Average of the groups"
+	case models.GroupRulePool:
+		return "Every vote as one group"
 	}
+	return "Sum of the groups"
+}
 
-	return fmt.Sprintf("%s - %s", trimFloat(last), trimFloat(current))
+// cardCount is how many cards there are to pick from in every row
+func cardCount(session models.Session) int {
+	count := 0
+	for _, row := range session.Rows {
+		count += len(session.RowCards(row))
+	}
+	return count
+}
+
+// timerText shows the remaining time as minutes and seconds, rounding up so it only reads 0:00 when the timer is done
+func timerText(remaining time.Duration) string {
+	seconds := int(math.Ceil(remaining.Seconds()))
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// recreateLink is the root page link that fills the form with the session's settings
+func recreateLink(session models.Session, host string) string {
+	query := url.Values{}
+	if session.Deck != "" {
+		query.Set("deck", session.Deck)
+	} else {
+		query.Set("cards", strings.Join(session.Cards, ","))
+	}
+	query.Set("cardValues", cardValues(session.SessionInfo))
+	query.Set("specialCards", strings.Join(session.SpecialCards, ","))
+	query.Set("coffeeBreakVotes", strconv.Itoa(session.CoffeeBreakVotes))
+	query.Set("rows", strings.Join(session.Rows, ","))
+	if len(session.RowDecks) > 0 {
+		query.Set("rowDecks", session.FormatRowDecks())
+	}
+	query.Set("mapToScale", strconv.FormatBool(session.MapToScale))
+	query.Set("scale", scaleValues(session.Scale))
+	query.Set("rounding", string(session.Rounding))
+	query.Set("aggregation", string(session.Aggregation))
+	query.Set("groups", strings.Join(session.Groups, ","))
+	query.Set("groupRule", string(session.GroupRule))
+	query.Set("timerSeconds", strconv.Itoa(session.TimerSeconds))
+	query.Set("timerAutoReveal", strconv.FormatBool(session.TimerAutoReveal))
+	query.Set("autoReveal", strconv.FormatBool(session.AutoReveal))
+	query.Set("autoRevealSeconds", strconv.Itoa(session.AutoRevealSeconds))
+	query.Set("openControls", strconv.FormatBool(session.OpenControls))
+	return host + "?" + query.Encode()
+}
+
+func exitLink(session models.Session, user models.User) string {
+	return userLink(session, user, "exit")
+}
+
+func userLink(session models.Session, user models.User, endpoint string) string {
+	return fmt.Sprintf("/session/%s/user/%s/%s", session.ID, user.ID, endpoint)
+}
+
+func trimFloat(f float64) string {
+	return strings.TrimRight(strings.TrimRight(strconv.FormatFloat(f, 'f', 2, 64), "0"), ".")
 }
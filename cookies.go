@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/joeyak/scrum-poker/models"
+)
+
+const (
+	secretEnv = "SCRUM_POKER_SECRET"
+	// apiTokenName is what api tokens are signed with so they can't be used as a cookie
+	apiTokenName = "token"
+	// passcodeHeader lets someone read a session with a passcode without joining it
+	passcodeHeader = "X-Session-Passcode"
+)
+
+// cookieSecret signs cookies and api tokens, it's random if no secret is given
+var cookieSecret []byte
+
+func newCookieSecret() []byte {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	return secret
+}
+
+// loadCookieSecret reads the secret from the file, or creates the file with a random secret if it doesn't exist
+func loadCookieSecret(path string) ([]byte, error) {
+	secret, err := os.ReadFile(path)
+	if err == nil {
+		if len(secret) == 0 {
+			return nil, errors.New("secret file is empty")
+		}
+		return secret, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	secret = []byte(base64.RawURLEncoding.EncodeToString(newCookieSecret()))
+	return secret, os.WriteFile(path, secret, 0o600)
+}
+
+func signature(name, value string) string {
+	mac := hmac.New(sha256.New, cookieSecret)
+	mac.Write([]byte(name + "=" + value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signValue adds a signature to the value. The name is part of the signature so a value can't be used for another cookie.
+func signValue(name, value string) string {
+	return value + "." + signature(name, value)
+}
+
+// verifyValue returns the value without the signature if the signature matches
+func verifyValue(name, signed string) (string, bool) {
+	index := strings.LastIndex(signed, ".")
+	if index < 0 {
+		return "", false
+	}
+
+	value, sig := signed[:index], signed[index+1:]
+	if !hmac.Equal([]byte(sig), []byte(signature(name, value))) {
+		return "", false
+	}
+	return value, true
+}
+
+func setSignedCookie(w http.ResponseWriter, cookie *http.Cookie) {
+	cookie.Value = signValue(cookie.Name, cookie.Value)
+	http.SetCookie(w, cookie)
+}
+
+func readSignedCookie(r *http.Request, name string) (string, bool) {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return "", false
+	}
+	return verifyValue(name, cookie.Value)
+}
+
+// requestUserID is the user making the request, from their session cookie or an api token
+func requestUserID(r *http.Request, session *models.Session) (string, bool) {
+	if userID, ok := readSignedCookie(r, session.ID); ok {
+		return userID, true
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return "", false
+	}
+	return verifyValue(apiTokenName, token)
+}
+
+// sessionAccess is the status to refuse the request with, or http.StatusOK if it can read the session.
+// Anyone can read a session without a passcode, otherwise the request has to be from one of its users or have the passcode.
+func sessionAccess(r *http.Request, session *models.Session) int {
+	if session.Passcode == nil {
+		return http.StatusOK
+	}
+
+	if userID, ok := requestUserID(r, session); ok {
+		if _, ok := session.User(userID); ok {
+			return http.StatusOK
+		}
+		return http.StatusForbidden
+	}
+
+	if passcode := r.Header.Get(passcodeHeader); passcode != "" {
+		if session.Passcode.Check(passcode) {
+			return http.StatusOK
+		}
+		return http.StatusForbidden
+	}
+
+	return http.StatusUnauthorized
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/joeyak/scrum-poker/models"
+)
+
+func TestSessionAccess(t *testing.T) {
+	server := newApiTestServer(t)
+	info := models.NewSessionInfo([]string{"1", "2", "3"}, nil, nil, true)
+
+	open, err := sessionManager.New(info, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	protected, err := sessionManager.New(info, "open sesame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := protected.NewUser("Ada", models.UserTypeParticipant, "", "open sesame")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		session *models.Session
+		// auth adds the credentials to the request
+		auth   func(r *http.Request)
+		status int
+	}{
+		{name: "no passcode", session: open, status: http.StatusOK},
+		{name: "nothing", session: protected, status: http.StatusUnauthorized},
+		{
+			name:    "wrong passcode",
+			session: protected,
+			auth:    func(r *http.Request) { r.Header.Set(passcodeHeader, "open barley") },
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "passcode",
+			session: protected,
+			auth:    func(r *http.Request) { r.Header.Set(passcodeHeader, "open sesame") },
+			status:  http.StatusOK,
+		},
+		{
+			name:    "bearer token of someone else",
+			session: protected,
+			auth:    func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+signValue(apiTokenName, "someone")) },
+			status:  http.StatusForbidden,
+		},
+		{
+			name:    "bearer token",
+			session: protected,
+			auth:    func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+signValue(apiTokenName, user.ID)) },
+			status:  http.StatusOK,
+		},
+		{
+			name:    "session cookie",
+			session: protected,
+			auth: func(r *http.Request) {
+				r.AddCookie(&http.Cookie{Name: protected.ID, Value: signValue(protected.ID, user.ID)})
+			},
+			status: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, path := range []string{"/api/v1/sessions/%s", "/session/%s/json", "/session/%s/export/csv"} {
+				r, err := http.NewRequest(http.MethodGet, server.URL+fmt.Sprintf(path, test.session.ID), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if test.auth != nil {
+					test.auth(r)
+				}
+
+				resp, err := http.DefaultClient.Do(r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != test.status {
+					t.Errorf("%s returned %d, wanted %d", path, resp.StatusCode, test.status)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadCookieSecret(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json.secret")
+
+	secret, err := loadCookieSecret(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) == 0 {
+		t.Fatal("secret is empty")
+	}
+
+	loaded, err := loadCookieSecret(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded, secret) {
+		t.Error("secret changed after loading it again")
+	}
+}
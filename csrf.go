@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+
+	"github.com/joeyak/scrum-poker/components"
+)
+
+const (
+	csrfCookieName = "csrf"
+	csrfHeaderName = "X-CSRF-Token"
+	csrfFormName   = "csrf"
+)
+
+// csrfMiddleware gives the browser a csrf token and checks it on requests that change state.
+// The token is put in the context so templates can add it to forms and htmx requests.
+func csrfMiddleware(handler http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := readSignedCookie(r, csrfCookieName)
+		if !ok {
+			buff := make([]byte, 32)
+			rand.Read(buff)
+			token = base64.RawURLEncoding.EncodeToString(buff)
+
+			setSignedCookie(w, &http.Cookie{
+				Name:     csrfCookieName,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+		default:
+			sent := r.Header.Get(csrfHeaderName)
+			if sent == "" {
+				sent = r.FormValue(csrfFormName)
+			}
+
+			if !ok || !hmac.Equal([]byte(sent), []byte(token)) {
+				slog.Warn("csrf token did not match", "path", r.URL.Path)
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		}
+
+		handler(w, r.WithContext(components.WithCSRFToken(r.Context(), token)))
+	})
+}
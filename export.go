@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joeyak/scrum-poker/models"
+)
+
+type exportRound struct {
+	Round int
+	Time  time.Time
+	Story *models.Story `json:",omitempty"`
+	Rows  []exportRow
+	Votes []models.Vote
+	Final float64
+	Unit  string
+	Range string `json:",omitempty"`
+	// Recommended is the final result rounded to the scale
+	Recommended string `json:",omitempty"`
+}
+
+type exportRow struct {
+	Name   string
+	Groups []exportDistribution
+	// Total is every group's votes pooled together
+	Total exportDistribution
+}
+
+type exportDistribution struct {
+	Group        string
+	Avg          float64
+	Distribution string
+	Median       float64
+	Min, Max     float64
+	StdDev       float64
+	// Mode and Spread are the most picked card and the lowest and highest cards picked
+	Mode   string `json:",omitempty"`
+	Spread string `json:",omitempty"`
+	// Special is the count of special cards like ? and ☕ which aren't in the average
+	Special string `json:",omitempty"`
+}
+
+func newExportDistribution(d models.Distribution) exportDistribution {
+	return exportDistribution{
+		Group:        d.Prefix,
+		Avg:          d.Avg(),
+		Distribution: d.Distribution(),
+		Median:       d.Median(),
+		Min:          d.Min(),
+		Max:          d.Max(),
+		StdDev:       d.StdDev(),
+		Mode:         d.Mode(),
+		Spread:       d.Spread(),
+		Special:      d.Special(),
+	}
+}
+
+func (d exportDistribution) avg() string {
+	return strconv.FormatFloat(d.Avg, 'f', -1, 64)
+}
+
+func newExportRounds(session models.Session) []exportRound {
+	var rounds []exportRound
+	for i, round := range session.History {
+		export := exportRound{
+			Round: i + 1,
+			Time:  round.Time,
+			Story: round.Story,
+			Votes: round.Votes,
+			Final: round.Final,
+			Unit:  "Days",
+		}
+
+		if session.MapsResult() {
+			export.Unit = "Points"
+			export.Range = session.FinalRange(round.Final)
+			export.Recommended = session.Recommended(round.Result())
+		}
+
+		for _, result := range round.Results {
+			export.Rows = append(export.Rows, newExportRow(result))
+		}
+
+		rounds = append(rounds, export)
+	}
+	return rounds
+}
+
+func newExportRow(result models.CalcResults) exportRow {
+	row := exportRow{Name: result.Name, Total: newExportDistribution(result.Total())}
+	for _, group := range result.Groups {
+		row.Groups = append(row.Groups, newExportDistribution(group))
+	}
+	return row
+}
+
+func (round exportRound) storyTitle() string {
+	if round.Story == nil {
+		return ""
+	}
+	return round.Story.Title
+}
+
+func (round exportRound) storyURL() string {
+	if round.Story == nil {
+		return ""
+	}
+	return round.Story.URL
+}
+
+func handleSessionExport(w http.ResponseWriter, r *http.Request) {
+	session := sessionManager.Get(r.PathValue("sessionID"))
+	if session == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if status := sessionAccess(r, session); status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
+
+	snapshot := session.Snapshot()
+	rounds := newExportRounds(snapshot)
+	format := r.PathValue("format")
+
+	var buff bytes.Buffer
+	var contentType, extension string
+	var err error
+	switch format {
+	case "csv":
+		contentType, extension = "text/csv", "csv"
+		err = writeCSVExport(&buff, snapshot.Groups, rounds)
+	case "json":
+		contentType, extension = "application/json", "json"
+		if rounds == nil {
+			rounds = []exportRound{}
+		}
+		var data []byte
+		data, err = json.MarshalIndent(rounds, "", "    ")
+		buff.Write(data)
+	case "md", "markdown":
+		contentType, extension = "text/markdown", "md"
+		writeMarkdownExport(&buff, snapshot.Groups, rounds)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		slog.ErrorContext(r.Context(), "could not export session", "sessionID", session.ID, "format", format, "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="scrum-poker-%s.%s"`, session.ID, extension))
+	w.Write(buff.Bytes())
+}
+
+// exportHeaders are the columns of the records, with columns for each of the session's groups
+func exportHeaders(groups []string) []string {
+	headers := []string{"Round", "Time", "Story", "Link", "Row"}
+	for _, group := range groups {
+		headers = append(headers, group+" Avg", group+" Distribution", group+" Special")
+	}
+	headers = append(headers, "Total Avg", "Total Distribution", "Total Special")
+	return append(headers, "Final", "Unit", "Range", "Recommended")
+}
+
+// exportRecords flattens the rounds into one record per round row
+func exportRecords(rounds []exportRound) [][]string {
+	var records [][]string
+	for _, round := range rounds {
+		for _, row := range round.Rows {
+			record := []string{
+				strconv.Itoa(round.Round),
+				round.Time.Format(time.RFC3339),
+				round.storyTitle(),
+				round.storyURL(),
+				row.Name,
+			}
+			for _, group := range row.Groups {
+				record = append(record, group.avg(), group.Distribution, group.Special)
+			}
+			record = append(record, row.Total.avg(), row.Total.Distribution, row.Total.Special)
+			records = append(records, append(record,
+				strconv.FormatFloat(round.Final, 'f', -1, 64),
+				round.Unit,
+				round.Range,
+				round.Recommended,
+			))
+		}
+	}
+	return records
+}
+
+func writeCSVExport(buff *bytes.Buffer, groups []string, rounds []exportRound) error {
+	writer := csv.NewWriter(buff)
+	writer.Write(exportHeaders(groups))
+	writer.WriteAll(exportRecords(rounds))
+	return writer.Error()
+}
+
+func writeMarkdownExport(buff *bytes.Buffer, groups []string, rounds []exportRound) {
+	escape := strings.NewReplacer("|", `\|`, "\n", " ", "\r", "")
+
+	writeRow := func(values []string) {
+		buff.WriteString("|")
+		for _, value := range values {
+			fmt.Fprintf(buff, " %s |", escape.Replace(value))
+		}
+		buff.WriteString("\n")
+	}
+
+	headers := exportHeaders(groups)
+	writeRow(headers)
+	buff.WriteString(strings.Repeat("| --- ", len(headers)) + "|\n")
+	for _, record := range exportRecords(rounds) {
+		writeRow(record)
+	}
+}
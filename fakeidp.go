@@ -0,0 +1,225 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joeyak/scrum-poker/components"
+)
+
+// fakeIdP is an OpenID Connect provider that runs in the server for local development and tests.
+// Anyone can log in as any name, so it should never be used for real sessions.
+type fakeIdP struct {
+	issuer string
+	key    *rsa.PrivateKey
+	kid    string
+
+	mu    sync.Mutex
+	codes map[string]fakeIdPCode
+}
+
+// fakeIdPCode is an authorization code waiting to be exchanged
+type fakeIdPCode struct {
+	clientID, redirectURI string
+	challenge, nonce      string
+	name                  string
+	expires               time.Time
+}
+
+func newFakeIdP(issuer string) (*fakeIdP, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate key: %w", err)
+	}
+
+	return &fakeIdP{
+		issuer: strings.TrimRight(issuer, "/"),
+		key:    key,
+		kid:    randomString()[:8],
+		codes:  map[string]fakeIdPCode{},
+	}, nil
+}
+
+// register adds the provider's endpoints under the path of the issuer url
+func (idp *fakeIdP) register(mux *Handler) error {
+	issuer, err := url.Parse(idp.issuer)
+	if err != nil {
+		return err
+	}
+	prefix := strings.TrimRight(issuer.Path, "/")
+
+	mux.HandleFuncUndocumented("GET "+prefix+"/.well-known/openid-configuration", idp.handleDiscovery)
+	mux.HandleFuncUndocumented("GET "+prefix+"/authorize", idp.handleAuthorize)
+	mux.HandleFuncUndocumented("POST "+prefix+"/authorize", idp.handleAuthorize)
+	mux.HandleFuncUndocumented("POST "+prefix+"/token", idp.handleToken)
+	mux.HandleFuncUndocumented("GET "+prefix+"/jwks", idp.handleJwks)
+	return nil
+}
+
+func (idp *fakeIdP) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeApiJson(w, http.StatusOK, map[string]any{
+		"issuer":                                idp.issuer,
+		"authorization_endpoint":                idp.issuer + "/authorize",
+		"token_endpoint":                        idp.issuer + "/token",
+		"jwks_uri":                              idp.issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (idp *fakeIdP) handleJwks(w http.ResponseWriter, r *http.Request) {
+	writeApiJson(w, http.StatusOK, jsonWebKeySet{Keys: []jsonWebKey{{
+		Kty: "RSA",
+		Kid: idp.kid,
+		Alg: "RS256",
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+	}}})
+}
+
+// handleAuthorize shows a form asking for a name, and once it's posted redirects back with a code
+func (idp *fakeIdP) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirectURI := query.Get("redirect_uri")
+	if query.Get("response_type") != "code" || query.Get("client_id") == "" || redirectURI == "" {
+		http.Error(w, "response_type code, client_id and redirect_uri are required", http.StatusBadRequest)
+		return
+	}
+	if query.Get("code_challenge") == "" || query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "a S256 code_challenge is required", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	if r.Method != http.MethodPost || name == "" {
+		err := components.FakeIdPLogin(r.URL.String()).Render(r.Context(), w)
+		if err != nil {
+			slog.Error("could not render fake idp login", "err", err)
+		}
+		return
+	}
+
+	code := randomString()
+	now := time.Now()
+	idp.mu.Lock()
+	// Codes that were never exchanged are dropped once they expire
+	for key, expired := range idp.codes {
+		if now.After(expired.expires) {
+			delete(idp.codes, key)
+		}
+	}
+	idp.codes[code] = fakeIdPCode{
+		clientID:    query.Get("client_id"),
+		redirectURI: redirectURI,
+		challenge:   query.Get("code_challenge"),
+		nonce:       query.Get("nonce"),
+		name:        name,
+		expires:     now.Add(time.Minute),
+	}
+	idp.mu.Unlock()
+
+	redirect, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	values := redirect.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	redirect.RawQuery = values.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (idp *fakeIdP) handleToken(w http.ResponseWriter, r *http.Request) {
+	tokenError := func(code, description string) {
+		writeApiJson(w, http.StatusBadRequest, map[string]string{"error": code, "error_description": description})
+	}
+
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		tokenError("unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+
+	clientID := r.PostFormValue("client_id")
+	if username, _, ok := r.BasicAuth(); ok {
+		clientID, _ = url.QueryUnescape(username)
+	}
+
+	// Codes can only be used once
+	idp.mu.Lock()
+	code, ok := idp.codes[r.PostFormValue("code")]
+	delete(idp.codes, r.PostFormValue("code"))
+	idp.mu.Unlock()
+
+	switch {
+	case !ok || time.Now().After(code.expires):
+		tokenError("invalid_grant", "unknown or expired code")
+		return
+	case code.clientID != clientID || code.redirectURI != r.PostFormValue("redirect_uri"):
+		tokenError("invalid_grant", "client_id or redirect_uri does not match")
+		return
+	case subtle.ConstantTimeCompare([]byte(pkceChallenge(r.PostFormValue("code_verifier"))), []byte(code.challenge)) != 1:
+		tokenError("invalid_grant", "code_verifier does not match")
+		return
+	}
+
+	now := time.Now()
+	idToken, err := idp.sign(idTokenClaims{
+		Issuer:            idp.issuer,
+		Subject:           "fake-" + strings.ToLower(strings.Join(strings.Fields(code.name), "-")),
+		Audience:          audience{code.clientID},
+		Expires:           now.Add(time.Hour).Unix(),
+		IssuedAt:          now.Unix(),
+		Nonce:             code.nonce,
+		Name:              code.name,
+		PreferredUsername: code.name,
+	})
+	if err != nil {
+		slog.Error("could not sign fake id token", "err", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeApiJson(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   int(time.Hour.Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+func (idp *fakeIdP) sign(claims idTokenClaims) (string, error) {
+	header, err := json.Marshal(jwtHeader{Alg: "RS256", Kid: idp.kid, Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newFakeIdPServer runs the site with the fake idp as its OpenID Connect provider
+func newFakeIdPServer(t *testing.T) (*httptest.Server, *fakeIdP) {
+	t.Helper()
+
+	var handler http.Handler
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	idp, err := newFakeIdP(server.URL + "/fake-idp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler, err = newHandler(false, idp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldOidc, oldSecret := oidc, cookieSecret
+	oidc = newOidcProvider(idp.issuer, "scrum-poker", "", "", false)
+	cookieSecret = newCookieSecret()
+	t.Cleanup(func() { oidc, cookieSecret = oldOidc, oldSecret })
+
+	return server, idp
+}
+
+// newLoginClient keeps cookies and stops at every redirect so each step of the login can be checked
+func newLoginClient(t *testing.T) *http.Client {
+	t.Helper()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func expectRedirect(t *testing.T, resp *http.Response, err error) *url.URL {
+	t.Helper()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("got status %s, wanted a redirect", resp.Status)
+	}
+	location, err := resp.Location()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location
+}
+
+// loginIdentity is the identity in the client's cookies
+func loginIdentity(t *testing.T, client *http.Client, server *httptest.Server) (identity, bool) {
+	t.Helper()
+
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, server.URL, nil)
+	for _, cookie := range client.Jar.Cookies(serverURL) {
+		r.AddCookie(cookie)
+	}
+	return requestIdentity(r)
+}
+
+func TestFakeIdPLogin(t *testing.T) {
+	tests := []struct {
+		name string
+		// tamper changes the authorize request the site made, like an attacker or a broken provider would
+		tamper func(query url.Values)
+		status int
+	}{
+		{
+			name:   "login",
+			status: http.StatusFound,
+		},
+		{
+			name:   "pkce challenge does not match",
+			tamper: func(query url.Values) { query.Set("code_challenge", pkceChallenge("someone else's verifier")) },
+			status: http.StatusBadGateway,
+		},
+		{
+			name:   "nonce does not match",
+			tamper: func(query url.Values) { query.Set("nonce", "replayed") },
+			status: http.StatusUnauthorized,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, _ := newFakeIdPServer(t)
+			client := newLoginClient(t)
+
+			resp, err := client.Get(server.URL + "/login?next=/session/abc")
+			authorize := expectRedirect(t, resp, err)
+			if !strings.HasPrefix(authorize.String(), server.URL+"/fake-idp/authorize") {
+				t.Fatalf("login redirected to %s, wanted the fake idp", authorize)
+			}
+
+			query := authorize.Query()
+			if test.tamper != nil {
+				test.tamper(query)
+			}
+			authorize.RawQuery = query.Encode()
+
+			resp, err = client.PostForm(authorize.String(), url.Values{"name": {"Ada Lovelace"}})
+			callback := expectRedirect(t, resp, err)
+			if callback.Path != callbackPath {
+				t.Fatalf("fake idp redirected to %s, wanted the callback", callback)
+			}
+
+			resp, err = client.Get(callback.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != test.status {
+				t.Fatalf("callback returned %s, wanted %d", resp.Status, test.status)
+			}
+
+			id, ok := loginIdentity(t, client, server)
+			if test.status != http.StatusFound {
+				if ok {
+					t.Errorf("logged in as %+v after a failed login", id)
+				}
+				return
+			}
+
+			if next, _ := resp.Location(); next == nil || next.Path != "/session/abc" {
+				t.Errorf("login redirected to %v, wanted /session/abc", next)
+			}
+			if !ok || id.Name != "Ada Lovelace" || id.Subject != "fake-ada-lovelace" {
+				t.Errorf("identity is %+v, wanted Ada Lovelace", id)
+			}
+		})
+	}
+}
+
+func TestFakeIdPPrunesExpiredCodes(t *testing.T) {
+	server, idp := newFakeIdPServer(t)
+	client := newLoginClient(t)
+
+	idp.codes["expired"] = fakeIdPCode{expires: time.Now().Add(-time.Second)}
+
+	resp, err := client.Get(server.URL + "/login")
+	authorize := expectRedirect(t, resp, err)
+	resp, err = client.PostForm(authorize.String(), url.Values{"name": {"Ada"}})
+	expectRedirect(t, resp, err)
+
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	if _, ok := idp.codes["expired"]; ok {
+		t.Error("expired code was not pruned")
+	}
+	if len(idp.codes) != 1 {
+		t.Errorf("there are %d codes, wanted the new one", len(idp.codes))
+	}
+}
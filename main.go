@@ -12,11 +12,16 @@ import (
 	"io"
 	"log/slog"
 	"mime"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/angelofallars/htmx-go"
@@ -28,18 +33,32 @@ import (
 
 var (
 	// Default Room Settings
-	defaultCards = []string{"1", "2", "3", "5", "8", "13"}
+	defaultDeck = "fibonacci"
 
 	//go:embed static/*
 	staticFS embed.FS
 
-	sessionManager = NewSessionManager()
+	sessionManager *SessionManager
+	transport      = components.TransportAuto
+	// allowedOrigins are the origin patterns allowed to open websockets besides the server's own host
+	allowedOrigins []string
 )
 
 func main() {
-	var addr string
-	var debugLog, logEndpoints, noColor bool
+	var addr, storeFile, transportFlag, secret, originsFlag string
+	var oidcIssuer, oidcClientID, oidcClientSecret, oidcRedirectURL string
+	var debugLog, logEndpoints, noColor, oidcRequireLogin, oidcFake bool
 	flag.StringVar(&addr, "addr", "0.0.0.0:8080", "Server Address")
+	flag.StringVar(&storeFile, "store-file", "", "File to persist sessions to, sessions are only kept in memory if empty")
+	flag.StringVar(&transportFlag, "transport", string(components.TransportAuto), "Session room transport: ws, sse or auto to fall back to sse when websockets can't connect")
+	flag.StringVar(&secret, "secret", os.Getenv(secretEnv), "Secret to sign cookies with, which can also be set with "+secretEnv+". If empty, a random one is kept next to the store file, or used until a restart without one")
+	flag.StringVar(&originsFlag, "allowed-origins", "", "Comma delimited list of origin host patterns, like *.example.com, that can open websockets besides the server's own host")
+	flag.StringVar(&oidcIssuer, "oidc-issuer", "", "OpenID Connect issuer url to let users log in, logging in is disabled if empty")
+	flag.StringVar(&oidcClientID, "oidc-client-id", "", "OpenID Connect client ID")
+	flag.StringVar(&oidcClientSecret, "oidc-client-secret", os.Getenv(oidcClientSecretEnv), "OpenID Connect client secret, which can also be set with "+oidcClientSecretEnv+". Leave empty for a public client that only uses PKCE")
+	flag.StringVar(&oidcRedirectURL, "oidc-redirect-url", "", "OpenID Connect redirect url, which is made from the request's host if empty. The path must be "+callbackPath)
+	flag.BoolVar(&oidcRequireLogin, "oidc-require-login", false, "Only let logged in users create sessions")
+	flag.BoolVar(&oidcFake, "oidc-fake", false, "Run a fake OpenID Connect provider at /fake-idp where anyone can log in as anyone, for development and tests")
 	flag.BoolVar(&debugLog, "debug", false, "Enable Debug Logging")
 	flag.BoolVar(&noColor, "no-color", false, "No Color Output")
 	flag.BoolVar(&logEndpoints, "log-endpoints", false, "Log Endpoints")
@@ -58,6 +77,76 @@ func main() {
 		}),
 	))
 
+	transport = components.Transport(transportFlag)
+	switch transport {
+	case components.TransportWebSocket, components.TransportSSE, components.TransportAuto:
+	default:
+		slog.Error("invalid transport", "transport", transportFlag)
+		os.Exit(1)
+	}
+
+	if originsFlag != "" {
+		allowedOrigins = strings.Split(originsFlag, ",")
+	}
+
+	switch {
+	case secret != "":
+		cookieSecret = []byte(secret)
+	case storeFile != "":
+		// The sessions outlive a restart, so the cookies of their users have to as well
+		secretFile := storeFile + ".secret"
+		var err error
+		cookieSecret, err = loadCookieSecret(secretFile)
+		if err != nil {
+			slog.Error("could not load secret file", "file", secretFile, "err", err)
+			os.Exit(1)
+		}
+	default:
+		slog.Warn("no secret given, using a random one so users have to rejoin their sessions after a restart")
+		cookieSecret = newCookieSecret()
+	}
+
+	var idp *fakeIdP
+	if oidcFake {
+		if oidcIssuer == "" {
+			_, port, _ := net.SplitHostPort(addr)
+			oidcIssuer = fmt.Sprintf("http://localhost:%s/fake-idp", port)
+		}
+		if oidcClientID == "" {
+			oidcClientID = "scrum-poker"
+		}
+
+		var err error
+		idp, err = newFakeIdP(oidcIssuer)
+		if err != nil {
+			slog.Error("could not create fake idp", "err", err)
+			os.Exit(1)
+		}
+		slog.Warn("running the fake OpenID Connect provider, anyone can log in as anyone", "issuer", oidcIssuer)
+	}
+
+	if oidcIssuer != "" {
+		if oidcClientID == "" {
+			slog.Error("oidc-client-id is needed with oidc-issuer")
+			os.Exit(1)
+		}
+		oidc = newOidcProvider(oidcIssuer, oidcClientID, oidcClientSecret, oidcRedirectURL, oidcRequireLogin)
+	} else if oidcRequireLogin {
+		slog.Error("oidc-require-login needs oidc-issuer or oidc-fake")
+		os.Exit(1)
+	}
+
+	var store SessionStore = NewMemoryStore()
+	if storeFile != "" {
+		fileStore, err := NewFileStore(storeFile)
+		if err != nil {
+			slog.Error("could not open store file", "file", storeFile, "err", err)
+			os.Exit(1)
+		}
+		store = fileStore
+	}
+	sessionManager = NewSessionManager(store)
+
 	go func() {
 		// Make sure to cleanup manager every 60 seconds so any sessions that expire are deleted
 		for {
@@ -66,29 +155,91 @@ func main() {
 		}
 	}()
 
+	mux, err := newHandler(logEndpoints, idp)
+	if err != nil {
+		slog.Error("could not register routes", "err", err)
+		os.Exit(1)
+	}
+
+	// The tests make sure the routes match the spec, so a mismatch here is only logged
+	err = mux.CheckOpenAPI(openAPISpec())
+	if err != nil {
+		slog.Error("routes and openapi spec do not match", "err", err)
+	}
+
+	slog.Info("Starting server", "addr", addr, "debug", debugLog, "noColor", noColor, "logEndpoints", logEndpoints, "storeFile", storeFile, "transport", transport, "allowedOrigins", allowedOrigins, "oidcIssuer", oidcIssuer, "oidcRequireLogin", oidcRequireLogin)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		err := http.ListenAndServe(addr, mux)
+		slog.Error("server stopped", "err", err)
+		stop()
+	}()
+	<-ctx.Done()
+
+	// The file store waits a moment before writing, so save what's left before exiting
+	err = sessionManager.Close()
+	if err != nil {
+		slog.Error("could not save sessions", "err", err)
+		os.Exit(1)
+	}
+}
+
+// newHandler registers every route, the fake idp's routes are added if it isn't nil
+func newHandler(logEndpoints bool, idp *fakeIdP) (Handler, error) {
 	mux := Handler{mux: http.NewServeMux(), logEndpoints: logEndpoints}
 
 	mux.Healthcheck("/healthcheck")
-	mux.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {})
-
-	mux.HandleFunc("/", htmxMiddleware(handleRoot))
-	mux.HandleFunc("GET /static/", handleStatic)
-	mux.HandleFunc("POST /new", handleNewSession)
-	mux.HandleFunc("GET /session/{sessionID}", htmxMiddleware(handleSession))
-	mux.HandleFunc("POST /session/{sessionID}", htmxMiddleware(handleSession))
-	mux.HandleFunc("POST /session/{sessionID}/join", handleSessionJoin)
+	mux.HandleFuncUndocumented("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {})
+
+	mux.HandleFuncUndocumented("/", htmxMiddleware(handleRoot), csrfMiddleware, loginMiddleware)
+	mux.HandleFuncUndocumented("GET /static/", handleStatic)
+	mux.HandleFunc("GET /openapi.json", handleOpenAPI)
+	mux.HandleFunc("GET /api/docs", handleApiDocs)
+	mux.HandleFunc("GET /login", handleLogin)
+	mux.HandleFunc("GET "+callbackPath, handleAuthCallback)
+	mux.HandleFunc("POST /logout", handleLogout, csrfMiddleware)
+	mux.HandleFunc("POST /new", handleNewSession, csrfMiddleware, loginMiddleware)
+	mux.HandleFunc("GET /session/{sessionID}", htmxMiddleware(handleSession), csrfMiddleware, loginMiddleware)
+	mux.HandleFunc("POST /session/{sessionID}", htmxMiddleware(handleSession), csrfMiddleware, loginMiddleware)
+	mux.HandleFunc("POST /session/{sessionID}/join", handleSessionJoin, csrfMiddleware, loginMiddleware)
 	mux.HandleFunc("GET /session/{sessionID}/json", handleSessionJson)
-	mux.HandleFunc("/session/{sessionID}/user/{userID}/exit", handleSessionExit)
+	mux.HandleFunc("GET /session/{sessionID}/export/{format}", handleSessionExport)
+	mux.HandleFunc("POST /session/{sessionID}/user/{userID}/exit", handleSessionExit, csrfMiddleware)
 	mux.HandleFunc("/session/{sessionID}/user/{userID}/ws", handleUserWs)
+	mux.HandleFunc("GET /session/{sessionID}/user/{userID}/sse", handleUserSse)
+	mux.HandleFunc("POST /session/{sessionID}/user/{userID}/action", handleUserAction, csrfMiddleware)
+
+	mux.HandleFuncUndocumented("/api/", handleApiNotFound)
+	mux.HandleFunc("POST /api/v1/sessions", handleApiSessionCreate)
+	mux.HandleFunc("GET /api/v1/sessions/{sessionID}", handleApiSession)
+	mux.HandleFunc("POST /api/v1/sessions/{sessionID}/users", handleApiUserJoin)
+	mux.HandleFunc("DELETE /api/v1/sessions/{sessionID}/users/{userID}", handleApiUserKick)
+	mux.HandleFunc("PUT /api/v1/sessions/{sessionID}/votes", handleApiVote)
+	mux.HandleFunc("DELETE /api/v1/sessions/{sessionID}/votes", handleApiVoteUndo)
+	mux.HandleFunc("POST /api/v1/sessions/{sessionID}/reveal", handleApiReveal)
+	mux.HandleFunc("POST /api/v1/sessions/{sessionID}/reset", handleApiReset)
+	mux.HandleFunc("POST /api/v1/sessions/{sessionID}/timer", handleApiTimerStart)
+	mux.HandleFunc("DELETE /api/v1/sessions/{sessionID}/timer", handleApiTimerStop)
+	mux.HandleFunc("PUT /api/v1/sessions/{sessionID}/facilitator", handleApiFacilitator)
+	mux.HandleFunc("PUT /api/v1/sessions/{sessionID}/settings", handleApiSettings)
+
+	if idp != nil {
+		err := idp.register(&mux)
+		if err != nil {
+			return Handler{}, fmt.Errorf("could not register fake idp: %w", err)
+		}
+	}
 
-	slog.Info("Starting server", "addr", addr, "debug", debugLog, "noColor", noColor, "logEndpoints", logEndpoints)
-	http.ListenAndServe(addr, mux)
+	return mux, nil
 }
 
 type Handler struct {
 	mux                *http.ServeMux
 	logEndpoints       bool
 	healthcheckPattern string
+	// documentedPatterns are the patterns that need to be in the openapi spec
+	documentedPatterns []string
 }
 
 func (h *Handler) Healthcheck(pattern string) {
@@ -100,7 +251,13 @@ func (h *Handler) Healthcheck(pattern string) {
 	h.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {})
 }
 
-func (h Handler) HandleFunc(pattern string, handler http.HandlerFunc, middlewares ...func(http.HandlerFunc) http.HandlerFunc) {
+func (h *Handler) HandleFunc(pattern string, handler http.HandlerFunc, middlewares ...func(http.HandlerFunc) http.HandlerFunc) {
+	h.documentedPatterns = append(h.documentedPatterns, pattern)
+	h.HandleFuncUndocumented(pattern, handler, middlewares...)
+}
+
+// HandleFuncUndocumented is the same as HandleFunc but the pattern isn't checked against the openapi spec
+func (h *Handler) HandleFuncUndocumented(pattern string, handler http.HandlerFunc, middlewares ...func(http.HandlerFunc) http.HandlerFunc) {
 	for _, middleware := range middlewares {
 		handler = middleware(handler)
 	}
@@ -114,11 +271,12 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			ips = r.RemoteAddr
 		}
 
-		attrs := []any{"ip", strings.Split(ips, ","), "path", r.RequestURI}
+		// The query is logged with the form so its secrets are redacted too
+		attrs := []any{"ip", strings.Split(ips, ","), "path", r.URL.Path}
 
 		r.ParseForm()
 		if len(r.Form) > 0 {
-			attrs = append(attrs, "form", r.Form)
+			attrs = append(attrs, "form", redactForm(r.Form))
 		}
 
 		slog.Info("endpoint hit", attrs...)
@@ -126,6 +284,21 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.mux.ServeHTTP(w, r)
 }
 
+// redactedFormKeys are form and query values that are never logged
+var redactedFormKeys = []string{"passcode", csrfFormName, "code", "state", "code_verifier", "client_secret"}
+
+// redactForm copies the form with the values of redactedFormKeys replaced
+func redactForm(form url.Values) url.Values {
+	redacted := url.Values{}
+	for key, values := range form {
+		if slices.Contains(redactedFormKeys, strings.ToLower(key)) {
+			values = []string{"REDACTED"}
+		}
+		redacted[key] = values
+	}
+	return redacted
+}
+
 func htmxMiddleware(handler http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !htmx.IsHTMX(r) {
@@ -150,14 +323,63 @@ func handleRoot(w http.ResponseWriter, r *http.Request) {
 	}
 
 	info := getInfoCookie(r)
+	if r.URL.Query().Has("deck") {
+		info.Session.UseDeck(r.URL.Query().Get("deck"))
+	}
 	if r.URL.Query().Has("cards") {
 		info.Session.Cards = strings.Split(r.URL.Query().Get("cards"), ",")
 	}
+	if r.URL.Query().Has("cardValues") {
+		if values, err := models.ParseCardValues(r.URL.Query().Get("cardValues")); err == nil {
+			info.Session.SetCardValues(values)
+		}
+	}
+	if r.URL.Query().Has("specialCards") {
+		info.Session.SpecialCards = splitList(r.URL.Query().Get("specialCards"))
+	}
+	if r.URL.Query().Has("coffeeBreakVotes") {
+		info.Session.CoffeeBreakVotes, _ = strconv.Atoi(r.URL.Query().Get("coffeeBreakVotes"))
+	}
 	if r.URL.Query().Has("rows") {
 		info.Session.Rows = strings.Split(r.URL.Query().Get("rows"), ",")
 	}
-	if r.URL.Query().Has("mapToFibonacci") {
-		info.Session.MapToFibonacci, _ = strconv.ParseBool("mapToFibonacci")
+	if r.URL.Query().Has("rowDecks") {
+		if rowDecks, err := models.ParseRowDecks(r.URL.Query().Get("rowDecks")); err == nil {
+			info.Session.RowDecks = rowDecks
+		}
+	}
+	if r.URL.Query().Has("mapToScale") {
+		info.Session.MapToScale, _ = strconv.ParseBool(r.URL.Query().Get("mapToScale"))
+	}
+	if r.URL.Query().Has("scale") {
+		info.Session.Scale, _ = models.ParseScale(r.URL.Query().Get("scale"))
+	}
+	if r.URL.Query().Has("rounding") {
+		info.Session.Rounding = models.Rounding(r.URL.Query().Get("rounding"))
+	}
+	if r.URL.Query().Has("aggregation") {
+		info.Session.Aggregation = models.Aggregation(r.URL.Query().Get("aggregation"))
+	}
+	if r.URL.Query().Has("groups") {
+		info.Session.Groups = splitList(r.URL.Query().Get("groups"))
+	}
+	if r.URL.Query().Has("groupRule") {
+		info.Session.GroupRule = models.GroupRule(r.URL.Query().Get("groupRule"))
+	}
+	if r.URL.Query().Has("timerSeconds") {
+		info.Session.TimerSeconds, _ = strconv.Atoi(r.URL.Query().Get("timerSeconds"))
+	}
+	if r.URL.Query().Has("timerAutoReveal") {
+		info.Session.TimerAutoReveal, _ = strconv.ParseBool(r.URL.Query().Get("timerAutoReveal"))
+	}
+	if r.URL.Query().Has("autoReveal") {
+		info.Session.AutoReveal, _ = strconv.ParseBool(r.URL.Query().Get("autoReveal"))
+	}
+	if r.URL.Query().Has("autoRevealSeconds") {
+		info.Session.AutoRevealSeconds, _ = strconv.Atoi(r.URL.Query().Get("autoRevealSeconds"))
+	}
+	if r.URL.Query().Has("openControls") {
+		info.Session.OpenControls, _ = strconv.ParseBool(r.URL.Query().Get("openControls"))
 	}
 
 	err := components.RootPage(info, "").Render(r.Context(), w)
@@ -166,6 +388,17 @@ func handleRoot(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// splitList splits a comma delimited list, leaving out empty values
+func splitList(value string) []string {
+	var values []string
+	for item := range strings.SplitSeq(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
 func handleStatic(w http.ResponseWriter, r *http.Request) {
 	file := strings.TrimPrefix(r.URL.Path, "/")
 	body, err := staticFS.ReadFile(file)
@@ -184,31 +417,84 @@ func handleStatic(w http.ResponseWriter, r *http.Request) {
 func handleNewSession(w http.ResponseWriter, r *http.Request) {
 	cards := strings.Split(r.FormValue("cards"), ",")
 	rows := strings.Split(r.FormValue("rows"), ",")
-	mapToFibonacci, _ := strconv.ParseBool(r.Form.Get("mapToFibonacci"))
+	mapToScale, _ := strconv.ParseBool(r.Form.Get("mapToScale"))
 
 	info := getInfoCookie(r)
-	info.Session = models.NewSessionInfo(cards, rows, mapToFibonacci)
+	info.Session = models.NewSessionInfo(cards, rows, splitList(r.FormValue("groups")), mapToScale)
+	info.Session.GroupRule = models.GroupRule(r.FormValue("groupRule"))
+	info.Session.Rounding = models.Rounding(r.FormValue("rounding"))
+	info.Session.Aggregation = models.Aggregation(r.FormValue("aggregation"))
+	scale, scaleErr := models.ParseScale(r.FormValue("scale"))
+	info.Session.Scale = scale
+	rowDecks, rowDecksErr := models.ParseRowDecks(r.FormValue("rowDecks"))
+	info.Session.RowDecks = rowDecks
+	if rowDecksErr == nil {
+		rowDecksErr = info.Session.ResolveRowDecks()
+	}
+	info.Session.OpenControls = r.Form.Has("openControls")
+	info.Session.SpecialCards = r.Form["specialCards"]
+	info.Session.CoffeeBreakVotes, _ = strconv.Atoi(r.FormValue("coffeeBreakVotes"))
+	info.Session.TimerSeconds, _ = strconv.Atoi(r.FormValue("timerSeconds"))
+	info.Session.TimerAutoReveal = r.Form.Has("timerAutoReveal")
+	info.Session.AutoReveal = r.Form.Has("autoReveal")
+	info.Session.AutoRevealSeconds, _ = strconv.Atoi(r.FormValue("autoRevealSeconds"))
+	deckErr := info.Session.UseDeck(r.FormValue("deck"))
+	cardValues, cardValuesErr := models.ParseCardValues(r.FormValue("cardValues"))
+	info.Session.SetCardValues(cardValues)
 
 	errorResponse := func(message string, err error) {
-		slog.Error(message, "err", err)
+		if err != nil {
+			slog.Error(message, "err", err)
+		}
 		err = components.RootPage(info, strings.ToUpper(message[0:1])+message[1:]).Render(r.Context(), w)
 		if err != nil {
 			slog.Error("could not render root page", "err", err)
 		}
 	}
 
-	for _, s := range cards {
-		_, err := strconv.ParseFloat(s, 64)
-		if err != nil {
-			errorResponse("invalid cards value", err)
-			return
-		}
+	if _, ok := requestIdentity(r); !ok && oidc != nil && oidc.requiredToCreate {
+		errorResponse("you need to log in to create a session", nil)
+		return
+	}
+
+	if deckErr != nil {
+		errorResponse("invalid deck", deckErr)
+		return
+	}
+	if cardValuesErr != nil {
+		errorResponse("invalid card values", cardValuesErr)
+		return
+	}
+	if scaleErr != nil {
+		errorResponse("invalid scale", scaleErr)
+		return
+	}
+	if rowDecksErr != nil {
+		errorResponse("invalid row decks", rowDecksErr)
+		return
+	}
+
+	err := info.Session.Validate()
+	if err != nil {
+		errorResponse("invalid session settings", err)
+		return
 	}
 
+	session, err := sessionManager.New(info.Session, r.FormValue("passcode"))
+	if err != nil {
+		errorResponse("could not create session", err)
+		return
+	}
 	setInfoCookie(w, info)
-	session := sessionManager.New(info.Session)
 
-	err := components.SessionCreated(*session, r.Header.Get("Origin")).Render(r.Context(), w)
+	setSignedCookie(w, &http.Cookie{
+		Name:    creatorCookieName(session),
+		Expires: session.Expires,
+		Value:   session.CreatorKey,
+		Path:    "/",
+	})
+
+	err = components.SessionCreated(session.Snapshot(), r.Header.Get("Origin")).Render(r.Context(), w)
 	if err != nil {
 		slog.Error("could not render root page", "err", err)
 	}
@@ -226,27 +512,45 @@ func handleSession(w http.ResponseWriter, r *http.Request) {
 	renderSessionJoin := func() {
 		info := getInfoCookie(r)
 
-		err := components.SessionJoin(*session, info).Render(r.Context(), w)
+		err := components.SessionJoin(session.Snapshot(), info, "").Render(r.Context(), w)
 		if err != nil {
 			slog.Error("could not render session join page", sessionAttr, "err", err)
 		}
 	}
 
-	userCookie, err := r.Cookie(session.ID)
-	if err != nil {
+	userID, ok := readSignedCookie(r, session.ID)
+	if !ok {
+		renderSessionJoin()
+		return
+	}
+
+	ok = session.UpdateUser(userID, func(user *models.User) { user.Active = true })
+	if !ok {
+		http.SetCookie(w, &http.Cookie{Name: session.ID, Path: "/", MaxAge: -1})
 		renderSessionJoin()
 		return
 	}
 
-	user := session.Users[userCookie.Value]
+	roomTransport := transport
+	if transport == components.TransportAuto && r.URL.Query().Get("transport") == string(components.TransportSSE) {
+		roomTransport = components.TransportSSE
+	}
+
+	ctx := r.Context()
+	if roomTransport == components.TransportSSE {
+		ctx = components.WithActionURL(ctx, actionLink(session, userID))
+	}
+
+	snapshot := session.Snapshot()
+	user := snapshot.Users[userID]
 	if user == nil {
+		// The user was kicked since being marked active
 		http.SetCookie(w, &http.Cookie{Name: session.ID, Path: "/", MaxAge: -1})
 		renderSessionJoin()
 		return
 	}
 
-	user.Active = true
-	err = components.SessionRoom(*session, *user).Render(r.Context(), w)
+	err := components.SessionRoom(snapshot, *user, roomTransport).Render(ctx, w)
 	if err != nil {
 		slog.Error("could not render root page", sessionAttr, "user", user.Name, "err", err)
 	}
@@ -261,12 +565,30 @@ func handleSessionJoin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	_, err := r.Cookie(session.ID)
-	if err != nil {
-		user := session.NewUser(r.FormValue("name"), models.UserType(r.FormValue("type")), r.Form.Has("isQA"))
-		slog.Info("user joined", "session", session.ID, "name", user.Name, "type", user.Type, "qa", user.IsQA)
+	if _, ok := readSignedCookie(r, session.ID); !ok {
+		// Logged in users always use the name from their login
+		name := r.FormValue("name")
+		if id, ok := requestIdentity(r); ok {
+			name = id.Name
+		}
+
+		user, err := session.NewUser(name, models.UserType(r.FormValue("type")), r.FormValue("group"), r.FormValue("passcode"))
+		if err != nil {
+			slog.Info("user could not join", "session", session.ID, "name", r.FormValue("name"), "err", err)
+			err = components.SessionJoin(session.Snapshot(), getInfoCookie(r), strings.ToUpper(err.Error()[0:1])+err.Error()[1:]).Render(r.Context(), w)
+			if err != nil {
+				slog.Error("could not render session join page", "session", session.ID, "err", err)
+			}
+			return
+		}
+		slog.Info("user joined", "session", session.ID, "name", user.Name, "type", user.Type, "group", user.Group)
+
+		if creatorKey, ok := readSignedCookie(r, creatorCookieName(session)); ok && session.ClaimFacilitator(user.ID, creatorKey) {
+			slog.Info("session creator is the facilitator", "session", session.ID, "name", user.Name)
+		}
+		session.SendUpdates()
 
-		http.SetCookie(w, &http.Cookie{
+		setSignedCookie(w, &http.Cookie{
 			Name:    session.ID,
 			Expires: session.Expires,
 			Value:   user.ID,
@@ -275,7 +597,7 @@ func handleSessionJoin(w http.ResponseWriter, r *http.Request) {
 
 		setInfoCookie(w, models.CookieData{
 			User:    user.UserInfo,
-			Session: session.SessionInfo,
+			Session: session.Snapshot().SessionInfo,
 		})
 	}
 
@@ -289,11 +611,15 @@ func handleSessionExit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user := session.Users[r.PathValue("userID")]
-	if user != nil {
-		slog.Info("removing user from session", "session", session.ID, "user", user.Name)
-		session.DeleteUser(user.ID)
-		session.SendUpdates()
+	// Anyone can leave but only the facilitator can kick others, which Apply checks
+	callerID, ok := requestUserID(r, session)
+	if ok {
+		_, err := session.Apply(callerID, models.KickUser{UserID: r.PathValue("userID")})
+		if err != nil {
+			slog.Warn("could not remove user from session", "session", session.ID, "user", r.PathValue("userID"), "err", err)
+		} else {
+			session.SendUpdates()
+		}
 	}
 
 	http.Redirect(w, r, fmt.Sprintf("/session/%s", session.ID), http.StatusFound)
@@ -305,9 +631,13 @@ func handleSessionJson(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
+	if status := sessionAccess(r, session); status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
 
 	var baseUsers []models.BaseUser
-	for _, user := range session.Users {
+	for _, user := range session.Snapshot().Users {
 		baseUsers = append(baseUsers, user.BaseUser)
 	}
 
@@ -329,22 +659,30 @@ func handleUserWs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user := session.Users[r.PathValue("userID")]
-	if user == nil {
+	user, ok := session.User(r.PathValue("userID"))
+	if !ok {
 		http.Redirect(w, r, "/", http.StatusFound)
 		return
 	}
 
+	if callerID, ok := requestUserID(r, session); !ok || callerID != user.ID {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
 	defer sessionManager.Cleanup()
 
 	logAttrs := slog.Group("", slog.String("session", session.ID), slog.String("user", user.Name))
 	defer func() {
 		slog.Debug("ws connection closing", logAttrs)
-		user.Active = false
+		session.UpdateUser(user.ID, func(user *models.User) { user.Active = false })
 		session.SendUpdates()
 	}()
 
-	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{InsecureSkipVerify: true})
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{
+		OriginPatterns: allowedOrigins,
+		Subprotocols:   []string{jsonSubprotocol},
+	})
 	if err != nil {
 		slog.Error("could not accept websocket connection", logAttrs, "err", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -352,6 +690,13 @@ func handleUserWs(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.CloseNow()
 
+	if conn.Subprotocol() == jsonSubprotocol {
+		session.UpdateUser(user.ID, func(user *models.User) { user.Active = true })
+		session.SendUpdates()
+		serveUserWsJson(r.Context(), conn, session, user, logAttrs)
+		return
+	}
+
 	renderError := func(message string, redirect bool) {
 		redirectLink := ""
 		if redirect {
@@ -370,7 +715,7 @@ func handleUserWs(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	user.Active = true
+	session.UpdateUser(user.ID, func(user *models.User) { user.Active = true })
 	session.SendUpdates()
 
 	ctx, cancel := context.WithCancel(r.Context())
@@ -387,63 +732,28 @@ func handleUserWs(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
-			value := struct {
-				Card, Row     string
-				UndoSelection bool
-				FlipType      bool
-				FlipQA        bool
-				ShowResults   bool
-				ResetResults  bool
-			}{}
-			err = json.Unmarshal(message, &value)
+			command, err := parseCommand(message)
 			if err != nil {
-				slog.Error("could not unmarshal value", logAttrs, "err", err)
+				slog.Error("could not parse command", logAttrs, "err", err)
 				renderError("An error occured while retrieving data", false)
 				continue
 			}
 
-			if value.ResetResults {
-				session.Reset()
+			_, err = session.Apply(user.ID, command)
+			if err != nil {
+				slog.Debug("could not apply command", logAttrs, "err", err)
+				renderError(err.Error(), false)
 				continue
 			}
 
-			if value.Card != "" {
-				user.Cards[value.Row] = value.Card
-				if value.UndoSelection {
-					delete(user.Cards, value.Row)
-				}
-				slog.Info("user updated cards", "user", user.Name, "cards", user.Cards)
-			}
-
-			if value.FlipQA {
-				user.IsQA = !user.IsQA
-			}
-
-			if value.FlipType {
-				if user.Type == models.UserTypeParticipant {
-					user.Type = models.UserTypeWatcher
-					clear(user.Cards)
-				} else {
-					user.Type = models.UserTypeParticipant
-				}
-			}
-
-			session.Showing = false
-			if value.ShowResults {
-				session.Showing = true
-			}
-
 			session.SendUpdates()
 		}
 	}()
 
 	// Kick off once so the user can get the updated UI
 	update := func() {
-		results := session.Calc()
-		showRevealButton := session.AllCardsSelected()
-
 		var buff bytes.Buffer
-		err = components.PokerContent(*session, *user, results, showRevealButton).Render(r.Context(), &buff)
+		err := renderPokerContent(r.Context(), &buff, session, user.ID)
 		if err != nil {
 			slog.Error("could not render poker content", logAttrs, "err", err)
 		}
@@ -458,22 +768,125 @@ func handleUserWs(w http.ResponseWriter, r *http.Request) {
 
 	for {
 		select {
-		case _, ok := <-user.UpdateCh:
-			if !ok {
-				renderError("Your connection has been forcibly closed. Redirecting...", true)
-				return
-			}
+		case <-user.UpdateCh:
 			update()
+		case <-user.Closed():
+			renderError("Your connection has been forcibly closed. Redirecting...", true)
+			return
 		case <-ctx.Done():
 			return
 		}
 	}
 }
 
+// renderPokerContent renders what the user sees in the session room, which is sent on every update
+func renderPokerContent(ctx context.Context, w io.Writer, session *models.Session, userID string) error {
+	results := session.Calc()
+	showRevealButton := session.AllCardsSelected()
+
+	snapshot := session.Snapshot()
+	user := snapshot.Users[userID]
+	if user == nil {
+		return nil
+	}
+
+	return components.PokerContent(snapshot, *user, results, showRevealButton).Render(ctx, w)
+}
+
+// parseCommand turns a message sent by htmx's ws-send, or a json-enc post, into a command
+func parseCommand(message []byte) (models.Command, error) {
+	value := struct {
+		Card, Row     string
+		UndoSelection bool
+		FlipType      bool
+		SetGroup      bool
+		Group         string
+		ShowResults   bool
+		ResetResults  bool
+
+		StartTimer bool
+		// TimerSeconds is a string since it comes from a form input
+		TimerSeconds string
+		StopTimer    bool
+
+		AddStory                               bool
+		StoryTitle, StoryDescription, StoryURL string
+		RemoveStory                            bool
+		StoryIndex                             int
+		NextStory                              bool
+
+		TransferFacilitator bool
+		UserID              string
+		SetOpenControls     bool
+		OpenControls        bool
+		SetLocked           bool
+		Locked              bool
+	}{}
+	err := json.Unmarshal(message, &value)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case value.ResetResults:
+		return models.ResetResults{}, nil
+	case value.ShowResults:
+		return models.ShowResults{}, nil
+	case value.StartTimer:
+		seconds := 0
+		if value.TimerSeconds != "" {
+			seconds, err = strconv.Atoi(value.TimerSeconds)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timer seconds: %w", err)
+			}
+		}
+		return models.StartTimer{Seconds: seconds}, nil
+	case value.StopTimer:
+		return models.StopTimer{}, nil
+	case value.FlipType:
+		return models.FlipType{}, nil
+	case value.SetGroup:
+		return models.SetGroup{Group: value.Group}, nil
+	case value.AddStory:
+		return models.AddStory{Story: models.Story{
+			Title:       value.StoryTitle,
+			Description: value.StoryDescription,
+			URL:         value.StoryURL,
+		}}, nil
+	case value.RemoveStory:
+		return models.RemoveStory{Index: value.StoryIndex}, nil
+	case value.NextStory:
+		return models.NextStory{}, nil
+	case value.TransferFacilitator:
+		return models.TransferFacilitator{UserID: value.UserID}, nil
+	case value.SetOpenControls:
+		return models.SetOpenControls{Enabled: value.OpenControls}, nil
+	case value.SetLocked:
+		return models.SetLocked{Enabled: value.Locked}, nil
+	case value.Card != "" && value.UndoSelection:
+		return models.UndoCard{Row: value.Row}, nil
+	case value.Card != "":
+		return models.SelectCard{Row: value.Row, Card: value.Card}, nil
+	}
+
+	return nil, errors.New("unknown command")
+}
+
+// creatorCookieName is the cookie with the session's creator key, so whoever created the session becomes the facilitator
+func creatorCookieName(session *models.Session) string {
+	return session.ID + "-creator"
+}
+
+func defaultSessionInfo() models.SessionInfo {
+	info := models.NewSessionInfo(nil, nil, nil, true)
+	info.UseDeck(defaultDeck)
+	return info
+}
+
 func getInfoCookie(r *http.Request) models.CookieData {
-	info := models.CookieData{Session: models.NewSessionInfo(defaultCards, nil, true)}
-	if cookie, _ := r.Cookie("info"); cookie != nil {
-		data, err := base64.StdEncoding.DecodeString(cookie.Value)
+	info := models.CookieData{Session: defaultSessionInfo()}
+	if value, ok := readSignedCookie(r, "info"); ok {
+		data, err := base64.StdEncoding.DecodeString(value)
 		if err == nil {
 			json.Unmarshal(data, &info)
 		}
@@ -486,7 +899,7 @@ func setInfoCookie(w http.ResponseWriter, info models.CookieData) {
 	if err != nil {
 		slog.Error("could not marshal info", "err", err)
 	} else {
-		http.SetCookie(w, &http.Cookie{
+		setSignedCookie(w, &http.Cookie{
 			Name:  "info",
 			Value: base64.StdEncoding.EncodeToString(data),
 			Path:  "/",
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestRedactForm(t *testing.T) {
+	form := url.Values{
+		"name":     {"Ada"},
+		"Passcode": {"open sesame"},
+		"csrf":     {"token"},
+		"code":     {"auth code"},
+	}
+
+	want := url.Values{
+		"name":     {"Ada"},
+		"Passcode": {"REDACTED"},
+		"csrf":     {"REDACTED"},
+		"code":     {"REDACTED"},
+	}
+	if got := redactForm(form); !reflect.DeepEqual(got, want) {
+		t.Errorf("redacted form is %v, wanted %v", got, want)
+	}
+	if form.Get("Passcode") != "open sesame" {
+		t.Error("redacting changed the request's form")
+	}
+}
@@ -9,34 +9,76 @@ import (
 )
 
 type SessionManager struct {
-	m map[string]*models.Session
+	store SessionStore
 }
 
-func NewSessionManager() SessionManager {
-	return SessionManager{m: map[string]*models.Session{}}
+func NewSessionManager(store SessionStore) *SessionManager {
+	manager := &SessionManager{store: store}
+	for _, session := range store.All() {
+		session.OnUpdate(manager.save)
+	}
+	return manager
 }
 
-func (manager *SessionManager) New(sessionInfo models.SessionInfo) *models.Session {
+// New creates a session, which needs the passcode to join if it isn't empty
+func (manager *SessionManager) New(sessionInfo models.SessionInfo, passcode string) (*models.Session, error) {
 	session := models.NewSession(uuid.NewString(), time.Now().Add(time.Hour*24), sessionInfo)
-	manager.m[session.ID] = session
-	return session
+	if passcode != "" {
+		var err error
+		session.Passcode, err = models.NewPasscode(passcode)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	session.OnUpdate(manager.save)
+	manager.save(session)
+	return session, nil
 }
 
 func (manager *SessionManager) Get(ID string) *models.Session {
-	session := manager.m[ID]
-	if session == nil || time.Now().After(session.Expires) {
-		delete(manager.m, ID)
+	session := manager.store.Get(ID)
+	if session == nil {
+		return nil
+	}
+	if time.Now().After(session.Expires) {
+		manager.delete(ID)
 		return nil
 	}
 	return session
 }
 
 func (manager *SessionManager) Cleanup() {
-	for ID := range manager.m {
-		if manager.m[ID].Expires.Before(time.Now()) {
-			slog.Info("closing expired session", "sessionID", ID)
-			manager.m[ID].Close()
-			delete(manager.m, ID)
+	var expired []string
+	for _, session := range manager.store.All() {
+		if session.Expires.Before(time.Now()) {
+			slog.Info("closing expired session", "sessionID", session.ID)
+			session.Close()
+			expired = append(expired, session.ID)
 		}
 	}
+
+	// Delete them together so the store only saves once
+	if len(expired) > 0 {
+		manager.delete(expired...)
+	}
+}
+
+// Close saves the sessions the store hasn't saved yet, such as before the server stops
+func (manager *SessionManager) Close() error {
+	return manager.store.Close()
+}
+
+func (manager *SessionManager) save(session *models.Session) {
+	err := manager.store.Put(session)
+	if err != nil {
+		slog.Error("could not save session", "sessionID", session.ID, "err", err)
+	}
+}
+
+func (manager *SessionManager) delete(IDs ...string) {
+	err := manager.store.Delete(IDs...)
+	if err != nil {
+		slog.Error("could not delete sessions", "sessionIDs", IDs, "err", err)
+	}
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joeyak/scrum-poker/models"
+)
+
+// TestSessionManagerCleanupRace cleans up expired sessions while other sessions are being created and used,
+// which is meant to be run with -race
+func TestSessionManagerCleanupRace(t *testing.T) {
+	manager := NewSessionManager(NewMemoryStore())
+	info := models.NewSessionInfo([]string{"1", "2", "3"}, nil, nil, true)
+
+	var expired, live []*models.Session
+	for i := range 10 {
+		session, err := manager.New(info, "")
+		if err != nil {
+			t.Fatalf("could not create session: %v", err)
+		}
+		if i%2 == 0 {
+			session.Expires = time.Now().Add(-time.Minute)
+			expired = append(expired, session)
+		} else {
+			live = append(live, session)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 25 {
+				manager.Cleanup()
+			}
+		}()
+	}
+	for _, session := range live {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 10 {
+				user, err := session.NewUser("user", models.UserTypeParticipant, "", "")
+				if err != nil {
+					t.Errorf("could not add user: %v", err)
+					return
+				}
+				if _, err := session.Apply(user.ID, models.SelectCard{Card: "2"}); err != nil {
+					t.Errorf("could not select card: %v", err)
+				}
+				if manager.Get(session.ID) == nil {
+					t.Errorf("live session %s was removed", session.ID)
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 10 {
+			if _, err := manager.New(info, ""); err != nil {
+				t.Errorf("could not create session: %v", err)
+			}
+		}
+	}()
+
+	wg.Wait()
+
+	for _, session := range expired {
+		if manager.Get(session.ID) != nil {
+			t.Errorf("expired session %s was not cleaned up", session.ID)
+		}
+	}
+}
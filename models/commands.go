@@ -0,0 +1,465 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrUnknownUser    = errors.New("user is not in the session")
+	ErrUnknownRow     = errors.New("row is not in the session")
+	ErrUnknownCard    = errors.New("card is not in the session")
+	ErrNotParticipant = errors.New("only participants can select cards")
+	ErrShowing        = errors.New("results are showing, clear them first")
+	ErrCardsMissing   = errors.New("all participants must choose their card(s) first")
+	ErrNoStoryTitle   = errors.New("a story needs a title")
+	ErrInvalidURL     = errors.New("story link must be a http or https url")
+	ErrUnknownStory   = errors.New("story is not in the queue")
+	ErrNotFacilitator = errors.New("only the facilitator can do that")
+	ErrWrongPasscode  = errors.New("wrong passcode")
+	ErrLocked         = errors.New("the session is locked")
+	ErrUnknownGroup   = errors.New("group is not in the session")
+)
+
+// Command is an action a user takes in a session. Commands are run with Session.Apply.
+type Command interface {
+	validate(session *Session, user *User) error
+	apply(session *Session, user *User) []Event
+}
+
+type EventType string
+
+var (
+	EventCardSelected EventType = "cardSelected"
+	EventCardUndone   EventType = "cardUndone"
+	EventTypeChanged  EventType = "typeChanged"
+	EventGroupChanged EventType = "groupChanged"
+	EventShown        EventType = "shown"
+	EventReset        EventType = "reset"
+	EventStoryAdded   EventType = "storyAdded"
+	EventStoryRemoved EventType = "storyRemoved"
+	EventNextStory    EventType = "nextStory"
+	EventUserJoined   EventType = "userJoined"
+	EventUserKicked   EventType = "userKicked"
+
+	EventFacilitatorChanged EventType = "facilitatorChanged"
+	EventSettingsChanged    EventType = "settingsChanged"
+)
+
+// Event is something that happened to a session.
+// Cards are left out so events can be shared before the results are shown.
+type Event struct {
+	Seq    int
+	Type   EventType
+	UserID string
+	Row    string `json:",omitempty"`
+}
+
+// maxEvents is how many events a session keeps for EventsSince
+const maxEvents = 100
+
+// logEvents numbers the events and keeps them so they can be sent to clients with EventsSince
+func (session *Session) logEvents(events ...Event) []Event {
+	for i := range events {
+		session.lastEventSeq++
+		events[i].Seq = session.lastEventSeq
+	}
+
+	session.events = append(session.events, events...)
+	if len(session.events) > maxEvents {
+		session.events = slices.Clone(session.events[len(session.events)-maxEvents:])
+	}
+	return events
+}
+
+// LastEventSeq is the sequence number of the last event, to use with EventsSince
+func (session *Session) LastEventSeq() int {
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+	return session.lastEventSeq
+}
+
+// EventsSince returns the kept events that happened after the sequence number
+func (session *Session) EventsSince(seq int) []Event {
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+
+	var events []Event
+	for _, event := range session.events {
+		if event.Seq > seq {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Apply validates and runs the command for the user. It does not send updates, so callers need to call SendUpdates.
+func (session *Session) Apply(userID string, command Command) ([]Event, error) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	user := session.Users[userID]
+	if user == nil {
+		return nil, ErrUnknownUser
+	}
+
+	err := command.validate(session, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return session.logEvents(command.apply(session, user)...), nil
+}
+
+// ParseCommand parses a command from json with a Type field naming the command, such as
+//
+//	{"Type": "selectCard", "Row": "", "Card": "5"}
+func ParseCommand(data []byte) (Command, error) {
+	var value struct {
+		Type    string
+		Row     string
+		Card    string
+		Index   int
+		UserID  string
+		Enabled bool
+		Group   string
+		Seconds int
+		Story
+	}
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return nil, err
+	}
+
+	switch value.Type {
+	case "selectCard":
+		return SelectCard{Row: value.Row, Card: value.Card}, nil
+	case "undoCard":
+		return UndoCard{Row: value.Row}, nil
+	case "flipType":
+		return FlipType{}, nil
+	case "setGroup":
+		return SetGroup{Group: value.Group}, nil
+	case "showResults":
+		return ShowResults{}, nil
+	case "resetResults":
+		return ResetResults{}, nil
+	case "startTimer":
+		return StartTimer{Seconds: value.Seconds}, nil
+	case "stopTimer":
+		return StopTimer{}, nil
+	case "addStory":
+		return AddStory{Story: value.Story}, nil
+	case "removeStory":
+		return RemoveStory{Index: value.Index}, nil
+	case "nextStory":
+		return NextStory{}, nil
+	case "kickUser":
+		return KickUser{UserID: value.UserID}, nil
+	case "transferFacilitator":
+		return TransferFacilitator{UserID: value.UserID}, nil
+	case "setOpenControls":
+		return SetOpenControls{Enabled: value.Enabled}, nil
+	case "setLocked":
+		return SetLocked{Enabled: value.Enabled}, nil
+	}
+
+	return nil, fmt.Errorf("unknown command type %q", value.Type)
+}
+
+// validateFacilitator checks the user can do facilitator actions, which is everyone if the session has open controls
+func (session *Session) validateFacilitator(user *User) error {
+	if !session.CanFacilitate(*user) {
+		return ErrNotFacilitator
+	}
+	return nil
+}
+
+func (session *Session) validateCard(user *User, row, card string) error {
+	if session.Showing {
+		return ErrShowing
+	}
+	if user.Type != UserTypeParticipant {
+		return ErrNotParticipant
+	}
+	if !slices.Contains(session.Rows, row) {
+		return ErrUnknownRow
+	}
+	if card != "" && !slices.Contains(session.RowCards(row), card) {
+		return ErrUnknownCard
+	}
+	return nil
+}
+
+type SelectCard struct {
+	Row, Card string
+}
+
+func (c SelectCard) validate(session *Session, user *User) error {
+	if c.Card == "" {
+		return ErrUnknownCard
+	}
+	return session.validateCard(user, c.Row, c.Card)
+}
+
+func (c SelectCard) apply(session *Session, user *User) []Event {
+	user.Cards[c.Row] = c.Card
+	slog.Info("user updated cards", "session", session.ID, "user", user.Name, "cards", user.Cards)
+	return []Event{{Type: EventCardSelected, UserID: user.ID, Row: c.Row}}
+}
+
+type UndoCard struct {
+	Row string
+}
+
+func (c UndoCard) validate(session *Session, user *User) error {
+	return session.validateCard(user, c.Row, "")
+}
+
+func (c UndoCard) apply(session *Session, user *User) []Event {
+	delete(user.Cards, c.Row)
+	slog.Info("user updated cards", "session", session.ID, "user", user.Name, "cards", user.Cards)
+	return []Event{{Type: EventCardUndone, UserID: user.ID, Row: c.Row}}
+}
+
+// FlipType switches the user between a participant and a watcher
+type FlipType struct{}
+
+func (c FlipType) validate(session *Session, user *User) error {
+	if session.Showing {
+		return ErrShowing
+	}
+	return nil
+}
+
+func (c FlipType) apply(session *Session, user *User) []Event {
+	if user.Type == UserTypeParticipant {
+		user.Type = UserTypeWatcher
+		clear(user.Cards)
+	} else {
+		user.Type = UserTypeParticipant
+	}
+	return []Event{{Type: EventTypeChanged, UserID: user.ID}}
+}
+
+// SetGroup moves the user to another of the session's groups
+type SetGroup struct {
+	Group string
+}
+
+func (c SetGroup) validate(session *Session, user *User) error {
+	if session.Showing {
+		return ErrShowing
+	}
+	if !slices.Contains(session.Groups, c.Group) {
+		return ErrUnknownGroup
+	}
+	return nil
+}
+
+func (c SetGroup) apply(session *Session, user *User) []Event {
+	user.Group = c.Group
+	return []Event{{Type: EventGroupChanged, UserID: user.ID}}
+}
+
+type ShowResults struct{}
+
+func (c ShowResults) validate(session *Session, user *User) error {
+	if err := session.validateFacilitator(user); err != nil {
+		return err
+	}
+	if session.Showing {
+		return ErrShowing
+	}
+	if !session.allCardsSelected() {
+		return ErrCardsMissing
+	}
+	return nil
+}
+
+func (c ShowResults) apply(session *Session, user *User) []Event {
+	slog.Info("showing results", "session", session.ID, "user", user.Name)
+	session.showResults()
+	return []Event{{Type: EventShown, UserID: user.ID}}
+}
+
+type ResetResults struct{}
+
+func (c ResetResults) validate(session *Session, user *User) error {
+	return session.validateFacilitator(user)
+}
+
+func (c ResetResults) apply(session *Session, user *User) []Event {
+	session.reset()
+	return []Event{{Type: EventReset, UserID: user.ID}}
+}
+
+type AddStory struct {
+	Story
+}
+
+func (c AddStory) validate(session *Session, user *User) error {
+	if err := session.validateFacilitator(user); err != nil {
+		return err
+	}
+	if strings.TrimSpace(c.Title) == "" {
+		return ErrNoStoryTitle
+	}
+	if link := strings.TrimSpace(c.URL); link != "" {
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return ErrInvalidURL
+		}
+	}
+	return nil
+}
+
+func (c AddStory) apply(session *Session, user *User) []Event {
+	session.Stories = append(session.Stories, Story{
+		Title:       strings.TrimSpace(c.Title),
+		Description: strings.TrimSpace(c.Description),
+		URL:         strings.TrimSpace(c.URL),
+	})
+	return []Event{{Type: EventStoryAdded, UserID: user.ID}}
+}
+
+// RemoveStory removes the story at the index of the queue, 0 being the current story
+type RemoveStory struct {
+	Index int
+}
+
+func (c RemoveStory) validate(session *Session, user *User) error {
+	if err := session.validateFacilitator(user); err != nil {
+		return err
+	}
+	if c.Index < 0 || c.Index >= len(session.Stories) {
+		return ErrUnknownStory
+	}
+	return nil
+}
+
+func (c RemoveStory) apply(session *Session, user *User) []Event {
+	session.Stories = slices.Delete(session.Stories, c.Index, c.Index+1)
+	return []Event{{Type: EventStoryRemoved, UserID: user.ID}}
+}
+
+// NextStory finishes the current story and resets the session for the next one
+type NextStory struct{}
+
+func (c NextStory) validate(session *Session, user *User) error {
+	if err := session.validateFacilitator(user); err != nil {
+		return err
+	}
+	if len(session.Stories) == 0 {
+		return ErrUnknownStory
+	}
+	return nil
+}
+
+func (c NextStory) apply(session *Session, user *User) []Event {
+	slog.Info("moving to next story", "session", session.ID, "user", user.Name, "story", session.Stories[0].Title)
+	session.Stories = session.Stories[1:]
+	session.reset()
+	return []Event{{Type: EventNextStory, UserID: user.ID}}
+}
+
+// KickUser removes the user from the session. Users can always remove themselves.
+type KickUser struct {
+	UserID string
+}
+
+func (c KickUser) validate(session *Session, user *User) error {
+	if session.Users[c.UserID] == nil {
+		return ErrUnknownUser
+	}
+	if c.UserID == user.ID {
+		return nil
+	}
+	return session.validateFacilitator(user)
+}
+
+func (c KickUser) apply(session *Session, user *User) []Event {
+	kicked := session.Users[c.UserID]
+	slog.Info("removing user from session", "session", session.ID, "user", kicked.Name, "by", user.Name)
+	kicked.Close()
+	delete(session.Users, kicked.ID)
+
+	return append([]Event{{Type: EventUserKicked, UserID: kicked.ID}}, session.ensureFacilitator()...)
+}
+
+// TransferFacilitator hands the facilitator role to another user
+type TransferFacilitator struct {
+	UserID string
+}
+
+func (c TransferFacilitator) validate(session *Session, user *User) error {
+	if !user.Facilitator {
+		return ErrNotFacilitator
+	}
+	if session.Users[c.UserID] == nil {
+		return ErrUnknownUser
+	}
+	return nil
+}
+
+func (c TransferFacilitator) apply(session *Session, user *User) []Event {
+	slog.Info("transferring facilitator", "session", session.ID, "from", user.Name, "to", session.Users[c.UserID].Name)
+	return []Event{session.setFacilitator(session.Users[c.UserID])}
+}
+
+// SetOpenControls lets everyone do facilitator actions, only the facilitator can change it
+type SetOpenControls struct {
+	Enabled bool
+}
+
+func (c SetOpenControls) validate(session *Session, user *User) error {
+	if !user.Facilitator {
+		return ErrNotFacilitator
+	}
+	return nil
+}
+
+func (c SetOpenControls) apply(session *Session, user *User) []Event {
+	session.OpenControls = c.Enabled
+	return []Event{{Type: EventSettingsChanged, UserID: user.ID}}
+}
+
+// SetLocked locks the session so no one new can join
+type SetLocked struct {
+	Enabled bool
+}
+
+func (c SetLocked) validate(session *Session, user *User) error {
+	return session.validateFacilitator(user)
+}
+
+func (c SetLocked) apply(session *Session, user *User) []Event {
+	slog.Info("changing session lock", "session", session.ID, "user", user.Name, "locked", c.Enabled)
+	session.Locked = c.Enabled
+	return []Event{{Type: EventSettingsChanged, UserID: user.ID}}
+}
+
+// Batch runs the commands together, they are all validated first so none of them run if any are invalid
+type Batch []Command
+
+func (c Batch) validate(session *Session, user *User) error {
+	for _, command := range c {
+		if err := command.validate(session, user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c Batch) apply(session *Session, user *User) []Event {
+	var events []Event
+	for _, command := range c {
+		events = append(events, command.apply(session, user)...)
+	}
+	return events
+}
@@ -0,0 +1,534 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestSession(info SessionInfo) *Session {
+	return NewSession("test", time.Now().Add(time.Hour), info)
+}
+
+func newTestUser(t *testing.T, session *Session, name string, userType UserType, group string) User {
+	t.Helper()
+
+	user, err := session.NewUser(name, userType, group, "")
+	if err != nil {
+		t.Fatalf("could not add user %q: %v", name, err)
+	}
+	session.UpdateUser(user.ID, func(user *User) { user.Active = true })
+	return user
+}
+
+type commandTestUsers struct {
+	facilitator, participant, watcher User
+}
+
+// newCommandTestSession has a session card row and a row with its own deck, a facilitator who is also a participant,
+// another participant and a watcher
+func newCommandTestSession(t *testing.T) (*Session, commandTestUsers) {
+	t.Helper()
+
+	info := NewSessionInfo([]string{"1", "2", "3", "5", "8"}, []string{"Complexity", "Risk"}, []string{"Dev", "QA"}, true)
+	info.RowDecks = map[string]RowDeck{"Risk": {Cards: []string{"low", "med", "high"}, CardValues: map[string]float64{"low": 1, "med": 2, "high": 3}}}
+	if err := info.Validate(); err != nil {
+		t.Fatalf("invalid session info: %v", err)
+	}
+
+	session := newTestSession(info)
+	users := commandTestUsers{
+		facilitator: newTestUser(t, session, "facilitator", UserTypeParticipant, ""),
+		participant: newTestUser(t, session, "participant", UserTypeParticipant, ""),
+		watcher:     newTestUser(t, session, "watcher", UserTypeWatcher, ""),
+	}
+	if !session.Users[users.facilitator.ID].Facilitator {
+		t.Fatal("first user is not the facilitator")
+	}
+	return session, users
+}
+
+// voteAll has every participant vote in every row
+func voteAll(session *Session) {
+	for _, user := range session.Users {
+		if user.Type == UserTypeParticipant {
+			user.Cards = map[string]string{"Complexity": "3", "Risk": "low"}
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(session *Session)
+		user    func(users commandTestUsers) string
+		command func(users commandTestUsers) Command
+		err     error
+		check   func(t *testing.T, session *Session, users commandTestUsers)
+	}{
+		{
+			name:    "select card",
+			user:    func(users commandTestUsers) string { return users.participant.ID },
+			command: func(users commandTestUsers) Command { return SelectCard{Row: "Complexity", Card: "5"} },
+			check: func(t *testing.T, session *Session, users commandTestUsers) {
+				if card := session.Users[users.participant.ID].Cards["Complexity"]; card != "5" {
+					t.Errorf("card is %q, wanted 5", card)
+				}
+			},
+		},
+		{
+			name:    "card not in the session",
+			user:    func(users commandTestUsers) string { return users.participant.ID },
+			command: func(users commandTestUsers) Command { return SelectCard{Row: "Complexity", Card: "13"} },
+			err:     ErrUnknownCard,
+		},
+		{
+			name:    "empty card",
+			user:    func(users commandTestUsers) string { return users.participant.ID },
+			command: func(users commandTestUsers) Command { return SelectCard{Row: "Complexity"} },
+			err:     ErrUnknownCard,
+		},
+		{
+			name:    "unknown row",
+			user:    func(users commandTestUsers) string { return users.participant.ID },
+			command: func(users commandTestUsers) Command { return SelectCard{Row: "Effort", Card: "5"} },
+			err:     ErrUnknownRow,
+		},
+		{
+			name:    "watcher selects card",
+			user:    func(users commandTestUsers) string { return users.watcher.ID },
+			command: func(users commandTestUsers) Command { return SelectCard{Row: "Complexity", Card: "5"} },
+			err:     ErrNotParticipant,
+		},
+		{
+			name:    "select card while showing",
+			setup:   func(session *Session) { session.Showing = true },
+			user:    func(users commandTestUsers) string { return users.participant.ID },
+			command: func(users commandTestUsers) Command { return SelectCard{Row: "Complexity", Card: "5"} },
+			err:     ErrShowing,
+		},
+		{
+			name:    "undo card",
+			setup:   voteAll,
+			user:    func(users commandTestUsers) string { return users.participant.ID },
+			command: func(users commandTestUsers) Command { return UndoCard{Row: "Complexity"} },
+			check: func(t *testing.T, session *Session, users commandTestUsers) {
+				if card, ok := session.Users[users.participant.ID].Cards["Complexity"]; ok {
+					t.Errorf("card is still %q", card)
+				}
+			},
+		},
+		{
+			name:    "undo card while showing",
+			setup:   func(session *Session) { session.Showing = true },
+			user:    func(users commandTestUsers) string { return users.participant.ID },
+			command: func(users commandTestUsers) Command { return UndoCard{Row: "Complexity"} },
+			err:     ErrShowing,
+		},
+		{
+			name:    "unknown user",
+			user:    func(users commandTestUsers) string { return "missing" },
+			command: func(users commandTestUsers) Command { return SelectCard{Row: "Complexity", Card: "5"} },
+			err:     ErrUnknownUser,
+		},
+		{
+			name:    "flip to watcher clears cards",
+			setup:   voteAll,
+			user:    func(users commandTestUsers) string { return users.participant.ID },
+			command: func(users commandTestUsers) Command { return FlipType{} },
+			check: func(t *testing.T, session *Session, users commandTestUsers) {
+				user := session.Users[users.participant.ID]
+				if user.Type != UserTypeWatcher || len(user.Cards) != 0 {
+					t.Errorf("user is a %s with cards %v, wanted a watcher without cards", user.Type, user.Cards)
+				}
+			},
+		},
+		{
+			name:    "flip type while showing",
+			setup:   func(session *Session) { session.Showing = true },
+			user:    func(users commandTestUsers) string { return users.watcher.ID },
+			command: func(users commandTestUsers) Command { return FlipType{} },
+			err:     ErrShowing,
+		},
+		{
+			name:    "show results with cards missing",
+			user:    func(users commandTestUsers) string { return users.facilitator.ID },
+			command: func(users commandTestUsers) Command { return ShowResults{} },
+			err:     ErrCardsMissing,
+		},
+		{
+			name:    "show results",
+			setup:   voteAll,
+			user:    func(users commandTestUsers) string { return users.facilitator.ID },
+			command: func(users commandTestUsers) Command { return ShowResults{} },
+			check: func(t *testing.T, session *Session, users commandTestUsers) {
+				if !session.Showing || len(session.History) != 1 {
+					t.Errorf("showing is %t with %d rounds, wanted the results showing with 1 round", session.Showing, len(session.History))
+				}
+			},
+		},
+		{
+			name:    "show results while showing",
+			setup:   func(session *Session) { voteAll(session); session.Showing = true },
+			user:    func(users commandTestUsers) string { return users.facilitator.ID },
+			command: func(users commandTestUsers) Command { return ShowResults{} },
+			err:     ErrShowing,
+		},
+		{
+			name:    "reset results",
+			setup:   func(session *Session) { voteAll(session); session.Showing = true },
+			user:    func(users commandTestUsers) string { return users.facilitator.ID },
+			command: func(users commandTestUsers) Command { return ResetResults{} },
+			check: func(t *testing.T, session *Session, users commandTestUsers) {
+				if session.Showing || len(session.Users[users.participant.ID].Cards) != 0 {
+					t.Error("results were not reset")
+				}
+			},
+		},
+		{
+			name: "add story",
+			user: func(users commandTestUsers) string { return users.facilitator.ID },
+			command: func(users commandTestUsers) Command {
+				return AddStory{Story: Story{Title: " Login ", URL: "https://example.com/1"}}
+			},
+			check: func(t *testing.T, session *Session, users commandTestUsers) {
+				if len(session.Stories) != 1 || session.Stories[0].Title != "Login" {
+					t.Errorf("stories are %+v, wanted the trimmed story", session.Stories)
+				}
+			},
+		},
+		{
+			name:    "story without title",
+			user:    func(users commandTestUsers) string { return users.facilitator.ID },
+			command: func(users commandTestUsers) Command { return AddStory{Story: Story{Title: " "}} },
+			err:     ErrNoStoryTitle,
+		},
+		{
+			name: "story with invalid link",
+			user: func(users commandTestUsers) string { return users.facilitator.ID },
+			command: func(users commandTestUsers) Command {
+				return AddStory{Story: Story{Title: "Login", URL: "javascript:alert(1)"}}
+			},
+			err: ErrInvalidURL,
+		},
+		{
+			name:    "remove story out of range",
+			setup:   func(session *Session) { session.Stories = []Story{{Title: "Login"}} },
+			user:    func(users commandTestUsers) string { return users.facilitator.ID },
+			command: func(users commandTestUsers) Command { return RemoveStory{Index: 1} },
+			err:     ErrUnknownStory,
+		},
+		{
+			name: "next story resets the round",
+			setup: func(session *Session) {
+				voteAll(session)
+				session.Showing = true
+				session.Stories = []Story{{Title: "Login"}, {Title: "Logout"}}
+			},
+			user:    func(users commandTestUsers) string { return users.facilitator.ID },
+			command: func(users commandTestUsers) Command { return NextStory{} },
+			check: func(t *testing.T, session *Session, users commandTestUsers) {
+				if len(session.Stories) != 1 || session.Stories[0].Title != "Logout" || session.Showing {
+					t.Errorf("stories are %+v with showing %t, wanted the next story without results", session.Stories, session.Showing)
+				}
+			},
+		},
+		{
+			name:    "next story without stories",
+			user:    func(users commandTestUsers) string { return users.facilitator.ID },
+			command: func(users commandTestUsers) Command { return NextStory{} },
+			err:     ErrUnknownStory,
+		},
+		{
+			name:    "participant shows results",
+			setup:   voteAll,
+			user:    func(users commandTestUsers) string { return users.participant.ID },
+			command: func(users commandTestUsers) Command { return ShowResults{} },
+			err:     ErrNotFacilitator,
+		},
+		{
+			name:    "participant resets results",
+			user:    func(users commandTestUsers) string { return users.participant.ID },
+			command: func(users commandTestUsers) Command { return ResetResults{} },
+			err:     ErrNotFacilitator,
+		},
+		{
+			name:    "participant resets results with open controls",
+			setup:   func(session *Session) { voteAll(session); session.Showing = true; session.OpenControls = true },
+			user:    func(users commandTestUsers) string { return users.participant.ID },
+			command: func(users commandTestUsers) Command { return ResetResults{} },
+			check: func(t *testing.T, session *Session, users commandTestUsers) {
+				if session.Showing || len(session.Users[users.participant.ID].Cards) != 0 {
+					t.Error("results were not reset")
+				}
+			},
+		},
+		{
+			name:    "participant adds story",
+			user:    func(users commandTestUsers) string { return users.participant.ID },
+			command: func(users commandTestUsers) Command { return AddStory{Story: Story{Title: "Login"}} },
+			err:     ErrNotFacilitator,
+		},
+		{
+			name:    "self kick",
+			user:    func(users commandTestUsers) string { return users.participant.ID },
+			command: func(users commandTestUsers) Command { return KickUser{UserID: users.participant.ID} },
+			check: func(t *testing.T, session *Session, users commandTestUsers) {
+				if session.Users[users.participant.ID] != nil {
+					t.Error("user is still in the session")
+				}
+			},
+		},
+		{
+			name:    "facilitator self kick hands over the role",
+			user:    func(users commandTestUsers) string { return users.facilitator.ID },
+			command: func(users commandTestUsers) Command { return KickUser{UserID: users.facilitator.ID} },
+			check: func(t *testing.T, session *Session, users commandTestUsers) {
+				facilitators := 0
+				for _, user := range session.Users {
+					if user.Facilitator {
+						facilitators++
+					}
+				}
+				if facilitators != 1 {
+					t.Errorf("there are %d facilitators, wanted 1", facilitators)
+				}
+			},
+		},
+		{
+			name:    "participant kicks another user",
+			user:    func(users commandTestUsers) string { return users.participant.ID },
+			command: func(users commandTestUsers) Command { return KickUser{UserID: users.watcher.ID} },
+			err:     ErrNotFacilitator,
+		},
+		{
+			name:    "facilitator kicks another user",
+			user:    func(users commandTestUsers) string { return users.facilitator.ID },
+			command: func(users commandTestUsers) Command { return KickUser{UserID: users.watcher.ID} },
+		},
+		{
+			name:    "kick unknown user",
+			user:    func(users commandTestUsers) string { return users.facilitator.ID },
+			command: func(users commandTestUsers) Command { return KickUser{UserID: "missing"} },
+			err:     ErrUnknownUser,
+		},
+		{
+			name:    "transfer facilitator",
+			user:    func(users commandTestUsers) string { return users.facilitator.ID },
+			command: func(users commandTestUsers) Command { return TransferFacilitator{UserID: users.watcher.ID} },
+			check: func(t *testing.T, session *Session, users commandTestUsers) {
+				if session.Users[users.facilitator.ID].Facilitator || !session.Users[users.watcher.ID].Facilitator {
+					t.Error("facilitator role was not handed over")
+				}
+			},
+		},
+		{
+			name:    "participant transfers facilitator with open controls",
+			setup:   func(session *Session) { session.OpenControls = true },
+			user:    func(users commandTestUsers) string { return users.participant.ID },
+			command: func(users commandTestUsers) Command { return TransferFacilitator{UserID: users.participant.ID} },
+			err:     ErrNotFacilitator,
+		},
+		{
+			name:    "participant sets open controls",
+			user:    func(users commandTestUsers) string { return users.participant.ID },
+			command: func(users commandTestUsers) Command { return SetOpenControls{Enabled: true} },
+			err:     ErrNotFacilitator,
+		},
+		{
+			name:    "lock the session",
+			user:    func(users commandTestUsers) string { return users.facilitator.ID },
+			command: func(users commandTestUsers) Command { return SetLocked{Enabled: true} },
+			check: func(t *testing.T, session *Session, users commandTestUsers) {
+				if !session.Locked {
+					t.Error("session is not locked")
+				}
+			},
+		},
+		{
+			name:    "participant locks the session",
+			user:    func(users commandTestUsers) string { return users.participant.ID },
+			command: func(users commandTestUsers) Command { return SetLocked{Enabled: true} },
+			err:     ErrNotFacilitator,
+		},
+		{
+			name:    "set group",
+			user:    func(users commandTestUsers) string { return users.participant.ID },
+			command: func(users commandTestUsers) Command { return SetGroup{Group: "QA"} },
+			check: func(t *testing.T, session *Session, users commandTestUsers) {
+				if group := session.Users[users.participant.ID].Group; group != "QA" {
+					t.Errorf("group is %q, wanted QA", group)
+				}
+			},
+		},
+		{
+			name:    "unknown group",
+			user:    func(users commandTestUsers) string { return users.participant.ID },
+			command: func(users commandTestUsers) Command { return SetGroup{Group: "UX"} },
+			err:     ErrUnknownGroup,
+		},
+		{
+			name:    "set group while showing",
+			setup:   func(session *Session) { session.Showing = true },
+			user:    func(users commandTestUsers) string { return users.participant.ID },
+			command: func(users commandTestUsers) Command { return SetGroup{Group: "QA"} },
+			err:     ErrShowing,
+		},
+		{
+			name:    "select row deck card",
+			user:    func(users commandTestUsers) string { return users.participant.ID },
+			command: func(users commandTestUsers) Command { return SelectCard{Row: "Risk", Card: "med"} },
+		},
+		{
+			name:    "session card in row deck row",
+			user:    func(users commandTestUsers) string { return users.participant.ID },
+			command: func(users commandTestUsers) Command { return SelectCard{Row: "Risk", Card: "5"} },
+			err:     ErrUnknownCard,
+		},
+		{
+			name:    "row deck card in session card row",
+			user:    func(users commandTestUsers) string { return users.participant.ID },
+			command: func(users commandTestUsers) Command { return SelectCard{Row: "Complexity", Card: "med"} },
+			err:     ErrUnknownCard,
+		},
+		{
+			name:    "start timer",
+			user:    func(users commandTestUsers) string { return users.facilitator.ID },
+			command: func(users commandTestUsers) Command { return StartTimer{Seconds: 30} },
+			check: func(t *testing.T, session *Session, users commandTestUsers) {
+				if session.Timer == nil || session.Timer.Expired {
+					t.Errorf("timer is %+v, wanted it running", session.Timer)
+				}
+			},
+		},
+		{
+			name:    "participant starts timer",
+			user:    func(users commandTestUsers) string { return users.participant.ID },
+			command: func(users commandTestUsers) Command { return StartTimer{Seconds: 30} },
+			err:     ErrNotFacilitator,
+		},
+		{
+			name:    "start timer while showing",
+			setup:   func(session *Session) { session.Showing = true },
+			user:    func(users commandTestUsers) string { return users.facilitator.ID },
+			command: func(users commandTestUsers) Command { return StartTimer{Seconds: 30} },
+			err:     ErrShowing,
+		},
+		{
+			name:    "start timer for too long",
+			user:    func(users commandTestUsers) string { return users.facilitator.ID },
+			command: func(users commandTestUsers) Command { return StartTimer{Seconds: 7200} },
+			err:     ErrInvalidTimer,
+		},
+		{
+			name:    "stop timer",
+			setup:   func(session *Session) { session.startTimer(time.Minute) },
+			user:    func(users commandTestUsers) string { return users.facilitator.ID },
+			command: func(users commandTestUsers) Command { return StopTimer{} },
+			check: func(t *testing.T, session *Session, users commandTestUsers) {
+				if session.Timer != nil {
+					t.Errorf("timer is %+v, wanted it stopped", session.Timer)
+				}
+			},
+		},
+		{
+			name:  "batch with an invalid command runs none of them",
+			setup: func(session *Session) { session.OpenControls = true },
+			user:  func(users commandTestUsers) string { return users.participant.ID },
+			command: func(users commandTestUsers) Command {
+				return Batch{SetLocked{Enabled: true}, SetOpenControls{Enabled: false}}
+			},
+			err: ErrNotFacilitator,
+			check: func(t *testing.T, session *Session, users commandTestUsers) {
+				if session.Locked || !session.OpenControls {
+					t.Errorf("locked is %t and open controls is %t, wanted them unchanged", session.Locked, session.OpenControls)
+				}
+			},
+		},
+		{
+			name: "batch",
+			user: func(users commandTestUsers) string { return users.facilitator.ID },
+			command: func(users commandTestUsers) Command {
+				return Batch{SetOpenControls{Enabled: true}, SetLocked{Enabled: true}}
+			},
+			check: func(t *testing.T, session *Session, users commandTestUsers) {
+				if !session.Locked || !session.OpenControls {
+					t.Errorf("locked is %t and open controls is %t, wanted both set", session.Locked, session.OpenControls)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			session, users := newCommandTestSession(t)
+			defer session.Close()
+			if test.setup != nil {
+				test.setup(session)
+			}
+
+			_, err := session.Apply(test.user(users), test.command(users))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, wanted %v", err, test.err)
+			}
+			if test.check != nil {
+				test.check(t, session, users)
+			}
+		})
+	}
+}
+
+func TestParseCommandRoundTrip(t *testing.T) {
+	commands := map[string]Command{
+		"selectCard":          SelectCard{Row: "Risk", Card: "med"},
+		"undoCard":            UndoCard{Row: "Risk"},
+		"flipType":            FlipType{},
+		"setGroup":            SetGroup{Group: "QA"},
+		"showResults":         ShowResults{},
+		"resetResults":        ResetResults{},
+		"startTimer":          StartTimer{Seconds: 90},
+		"stopTimer":           StopTimer{},
+		"addStory":            AddStory{Story: Story{Title: "Login", Description: "Let users log in", URL: "https://example.com/1"}},
+		"removeStory":         RemoveStory{Index: 2},
+		"nextStory":           NextStory{},
+		"kickUser":            KickUser{UserID: "user"},
+		"transferFacilitator": TransferFacilitator{UserID: "user"},
+		"setOpenControls":     SetOpenControls{Enabled: true},
+		"setLocked":           SetLocked{Enabled: true},
+	}
+
+	for commandType, command := range commands {
+		t.Run(commandType, func(t *testing.T) {
+			data, err := json.Marshal(command)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fields map[string]any
+			if err := json.Unmarshal(data, &fields); err != nil {
+				t.Fatal(err)
+			}
+			fields["Type"] = commandType
+			data, err = json.Marshal(fields)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			parsed, err := ParseCommand(data)
+			if err != nil {
+				t.Fatalf("could not parse %s: %v", data, err)
+			}
+			if !reflect.DeepEqual(parsed, command) {
+				t.Errorf("parsed %#v from %s, wanted %#v", parsed, data, command)
+			}
+		})
+	}
+
+	if _, err := ParseCommand([]byte(`{"Type": "dance"}`)); err == nil {
+		t.Error("unknown command type was parsed")
+	}
+	if _, err := ParseCommand([]byte(`{`)); err == nil {
+		t.Error("invalid json was parsed")
+	}
+}
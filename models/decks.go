@@ -0,0 +1,147 @@
+package models
+
+import (
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Special cards can be added to any deck, they aren't averaged and are counted separately
+const (
+	CardUnsure   = "?"
+	CardCoffee   = "☕"
+	CardInfinity = "∞"
+	CardPass     = "pass"
+)
+
+var SpecialCards = []string{CardUnsure, CardCoffee, CardInfinity, CardPass}
+
+func IsSpecialCard(card string) bool {
+	return slices.Contains(SpecialCards, card)
+}
+
+// Deck is a preset of cards and the number each card counts as when averaging
+type Deck struct {
+	ID     string
+	Name   string
+	Cards  []string
+	Values map[string]float64
+	// Sizes shows the results as the cards, like T-shirt sizes, instead of numbers
+	Sizes bool
+}
+
+var Decks = []Deck{
+	numericDeck("fibonacci", "Fibonacci", "1", "2", "3", "5", "8", "13", "21"),
+	{
+		ID:    "modified-fibonacci",
+		Name:  "Modified Fibonacci",
+		Cards: []string{"0", "½", "1", "2", "3", "5", "8", "13", "20", "40", "100"},
+		Values: map[string]float64{
+			"0": 0, "½": 0.5, "1": 1, "2": 2, "3": 3, "5": 5, "8": 8, "13": 13, "20": 20, "40": 40, "100": 100,
+		},
+	},
+	{
+		ID:    "t-shirt",
+		Name:  "T-Shirt",
+		Cards: []string{"XS", "S", "M", "L", "XL", "XXL"},
+		Values: map[string]float64{
+			"XS": 1, "S": 2, "M": 3, "L": 5, "XL": 8, "XXL": 13,
+		},
+		Sizes: true,
+	},
+	numericDeck("powers-of-two", "Powers of Two", "1", "2", "4", "8", "16", "32", "64"),
+	numericDeck("hours", "Hours", "1", "2", "4", "6", "8", "12", "16", "24", "40"),
+}
+
+func numericDeck(ID, name string, cards ...string) Deck {
+	deck := Deck{ID: ID, Name: name, Cards: cards, Values: map[string]float64{}}
+	for _, card := range cards {
+		deck.Values[card], _ = strconv.ParseFloat(card, 64)
+	}
+	return deck
+}
+
+func GetDeck(ID string) (Deck, bool) {
+	for _, deck := range Decks {
+		if deck.ID == ID {
+			return deck, true
+		}
+	}
+	return Deck{}, false
+}
+
+// UseDeck sets the cards to the deck's cards. An empty ID is a custom deck which keeps the cards.
+func (info *SessionInfo) UseDeck(ID string) error {
+	if ID == "" {
+		info.Deck = ""
+		return nil
+	}
+
+	deck, ok := GetDeck(ID)
+	if !ok {
+		return fmt.Errorf("unknown deck %q", ID)
+	}
+
+	info.Deck = deck.ID
+	info.Cards = deck.Cards
+	info.CardValues = deck.Values
+	info.Sizes = deck.Sizes
+	return nil
+}
+
+// ParseCardValues parses a comma delimited list of card=number
+func ParseCardValues(text string) (map[string]float64, error) {
+	values := map[string]float64{}
+	for pair := range strings.SplitSeq(text, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		card, number, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("card value %q is not card=number", pair)
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+		if err != nil || math.IsInf(value, 0) || math.IsNaN(value) {
+			return nil, fmt.Errorf("card value %q is not a number", number)
+		}
+		values[strings.TrimSpace(card)] = value
+	}
+	return values, nil
+}
+
+// SetCardValues changes what the session's cards count as, values for cards not in the deck are ignored
+func (info *SessionInfo) SetCardValues(values map[string]float64) {
+	cardValues := maps.Clone(info.CardValues)
+	if cardValues == nil {
+		cardValues = map[string]float64{}
+	}
+	for _, card := range info.Cards {
+		if value, ok := values[card]; ok {
+			cardValues[card] = value
+		}
+	}
+	info.CardValues = cardValues
+}
+
+// CardValue is the number the card counts as, which is from the deck or the card itself for custom decks
+func (info SessionInfo) CardValue(card string) (float64, bool) {
+	return cardValue(info.CardValues, card)
+}
+
+func cardValue(values map[string]float64, card string) (float64, bool) {
+	if value, ok := values[card]; ok {
+		return value, true
+	}
+
+	value, err := strconv.ParseFloat(card, 64)
+	return value, err == nil && !math.IsInf(value, 0) && !math.IsNaN(value)
+}
+
+// DeckCards are the cards users can pick, which are the cards followed by the special cards
+func (info SessionInfo) DeckCards() []string {
+	return slices.Concat(info.Cards, info.SpecialCards)
+}
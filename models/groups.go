@@ -0,0 +1,77 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// GroupRule is how the groups' results are combined into the final result
+type GroupRule string
+
+var (
+	GroupRuleSum     GroupRule = "sum"
+	GroupRuleMax     GroupRule = "max"
+	GroupRuleAverage GroupRule = "average"
+	// GroupRulePool uses the total of every vote as if there was one group
+	GroupRulePool GroupRule = "pool"
+)
+
+var GroupRules = []GroupRule{GroupRuleSum, GroupRuleMax, GroupRuleAverage, GroupRulePool}
+
+var DefaultGroups = []string{"Dev", "QA"}
+
+func (info SessionInfo) validateGroups() error {
+	if len(info.Groups) == 0 {
+		return errors.New("no groups")
+	}
+	for i, group := range info.Groups {
+		if group == "" {
+			return errors.New("groups need a name")
+		}
+		if slices.Contains(info.Groups[:i], group) {
+			return fmt.Errorf("group %q is in the session twice", group)
+		}
+	}
+	if info.GroupRule != "" && !slices.Contains(GroupRules, info.GroupRule) {
+		return fmt.Errorf("unknown group rule %q", info.GroupRule)
+	}
+	return nil
+}
+
+// userGroup is the group the user is estimating for, which is the first group if the user's group isn't in the session
+func (info SessionInfo) userGroup(group string) int {
+	return max(slices.Index(info.Groups, group), 0)
+}
+
+// Final is the final result of the results. Sum, max and average combine each group's aggregation,
+// while pool aggregates the total of every vote.
+func (info SessionInfo) Final(result CalcResults) float64 {
+	if info.GroupRule == GroupRulePool {
+		return result.Total().Aggregate(info.Aggregation)
+	}
+
+	var amounts []float64
+	for _, group := range result.Groups {
+		// Groups with only special cards have nothing to aggregate
+		if group.Counted() {
+			amounts = append(amounts, group.Aggregate(info.Aggregation))
+		}
+	}
+	if len(amounts) == 0 {
+		return 0
+	}
+
+	total := 0.0
+	for _, amount := range amounts {
+		total += amount
+	}
+
+	switch info.GroupRule {
+	case GroupRuleMax:
+		return slices.Max(amounts)
+	case GroupRuleAverage:
+		return total / float64(len(amounts))
+	}
+	return total
+}
@@ -1,9 +1,14 @@
 package models
 
 import (
+	"cmp"
 	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"slices"
 	"strconv"
 	"strings"
@@ -19,22 +24,98 @@ type CookieData struct {
 }
 
 type SessionInfo struct {
-	Cards          []string
-	Rows           []string
-	MapToFibonacci bool
+	// Deck is the ID of the deck the cards are from, it's empty for custom cards
+	Deck  string
+	Cards []string
+	// CardValues is what the deck's cards count as, custom cards are parsed as numbers
+	CardValues map[string]float64 `json:",omitempty"`
+	// Sizes shows the results as the cards, like T-shirt sizes, using the card values to add up rows
+	Sizes bool `json:",omitempty"`
+	// SpecialCards are the special cards like ? and ☕ added to the deck
+	SpecialCards []string `json:",omitempty"`
+	// CoffeeBreakVotes is how many ☕ votes it takes to suggest a break, zero never suggests one
+	CoffeeBreakVotes int `json:",omitempty"`
+	Rows             []string
+	// RowDecks are the rows that have their own cards or weight, keyed by the row
+	RowDecks map[string]RowDeck `json:",omitempty"`
+	// MapToScale maps the final result to the scale instead of showing it as days
+	MapToScale bool
+	// Scale is what the final result is mapped to, the card values are used if it's empty
+	Scale    []float64 `json:",omitempty"`
+	Rounding Rounding  `json:",omitempty"`
+	// Aggregation is how each distribution is turned into the final result, which is the mean if empty
+	Aggregation Aggregation `json:",omitempty"`
+	// Groups are what users estimate for, like Dev and QA, which are combined with the group rule
+	Groups    []string
+	GroupRule GroupRule `json:",omitempty"`
+	// TimerSeconds is how long the round timer runs when the facilitator doesn't pick a duration
+	TimerSeconds int `json:",omitempty"`
+	// TimerAutoReveal shows the results when the timer runs out if every active participant voted, instead of only warning
+	TimerAutoReveal bool `json:",omitempty"`
+	// AutoReveal shows the results once every active participant has voted and the grace period has passed
+	AutoReveal bool `json:",omitempty"`
+	// AutoRevealSeconds is the grace period, so votes can still be changed before the results show
+	AutoRevealSeconds int `json:",omitempty"`
+	// OpenControls lets every user do what only the facilitator can do, like showing results and kicking users
+	OpenControls bool
 }
 
-func NewSessionInfo(cards, rows []string, mapToFibonacci bool) SessionInfo {
+func NewSessionInfo(cards, rows, groups []string, mapToScale bool) SessionInfo {
 	if len(rows) == 0 {
 		rows = []string{""}
 	}
+	if len(groups) == 0 {
+		groups = DefaultGroups
+	}
 	return SessionInfo{
-		Cards:          cards,
-		Rows:           rows,
-		MapToFibonacci: mapToFibonacci,
+		Cards:      cards,
+		Rows:       rows,
+		Groups:     groups,
+		MapToScale: mapToScale,
+	}
+}
+
+// Validate checks that every card can be averaged
+func (info SessionInfo) Validate() error {
+	if len(info.Cards) == 0 {
+		return errors.New("no cards")
+	}
+	for _, card := range info.Cards {
+		if _, ok := info.CardValue(card); !ok {
+			return fmt.Errorf("card %q is not a number", card)
+		}
+	}
+	for i, card := range info.SpecialCards {
+		if !IsSpecialCard(card) {
+			return fmt.Errorf("card %q is not a special card", card)
+		}
+		if slices.Contains(info.SpecialCards[:i], card) {
+			return fmt.Errorf("card %q is in the deck twice", card)
+		}
+	}
+	if info.CoffeeBreakVotes < 0 {
+		return errors.New("coffee break votes can't be negative")
+	}
+	if info.Rounding != "" && !slices.Contains(Roundings, info.Rounding) {
+		return fmt.Errorf("unknown rounding %q", info.Rounding)
+	}
+	if info.Aggregation != "" && !slices.Contains(Aggregations, info.Aggregation) {
+		return fmt.Errorf("unknown aggregation %q", info.Aggregation)
 	}
+	if err := info.validateRowDecks(); err != nil {
+		return err
+	}
+	if err := info.validateTimer(); err != nil {
+		return err
+	}
+	if err := info.validateAutoReveal(); err != nil {
+		return err
+	}
+	return info.validateGroups()
 }
 
+// Session is shared between every handler and websocket connection of its users,
+// so all access to its state goes through the methods which hold mu.
 type Session struct {
 	SessionInfo
 	ID      string
@@ -42,10 +123,33 @@ type Session struct {
 	Showing bool
 
 	Users map[string]*User
+	// CreatorKey is given to whoever created the session so they become the facilitator when they join
+	CreatorKey string
+	// Passcode is needed to join the session if it's set
+	Passcode *Passcode `json:",omitempty"`
+	// Locked stops new users from joining
+	Locked bool
+
+	// Stories is the queue of stories to estimate, the first one is the current story
+	Stories []Story
+	// History has a round for every time the results were shown, oldest first
+	History []Round
+	// Timer is the countdown of the current round, if one was started
+	Timer *Timer `json:",omitempty"`
 
 	lastResults []CalcResults
 
-	cancels []func()
+	events       []Event
+	lastEventSeq int
+
+	timer *time.Timer
+	// reveal is the pending auto reveal, which shows the results at revealAt
+	reveal   *time.Timer
+	revealAt time.Time
+
+	mu       *sync.RWMutex
+	cancels  []func()
+	onUpdate func(*Session)
 }
 
 func NewSession(ID string, Expires time.Time, sessionInfo SessionInfo) *Session {
@@ -54,27 +158,167 @@ func NewSession(ID string, Expires time.Time, sessionInfo SessionInfo) *Session
 		ID:          ID,
 		Expires:     Expires,
 		Users:       map[string]*User{},
+		CreatorKey:  uuid.NewString(),
+		mu:          &sync.RWMutex{},
 	}
 }
 
-func (session *Session) NewUser(name string, userType UserType, isQA bool) *User {
+// NewUser adds a user to the session if it isn't locked and the passcode matches
+func (session *Session) NewUser(name string, userType UserType, group string, passcode string) (User, error) {
+	// Check the passcode before locking since hashing it is slow
+	if session.Passcode != nil && !session.Passcode.Check(passcode) {
+		return User{}, ErrWrongPasscode
+	}
+
+	if group == "" {
+		group = session.Groups[0]
+	}
+	if !slices.Contains(session.Groups, group) {
+		return User{}, ErrUnknownGroup
+	}
+
 	user := &User{
 		BaseUser: BaseUser{
 			UserInfo: UserInfo{
-				Name: name,
-				Type: userType,
-				IsQA: isQA,
+				Name:  name,
+				Type:  userType,
+				Group: group,
 			},
 			ID:    uuid.NewString(),
 			Cards: map[string]string{},
 		},
 		UpdateCh: make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.Locked {
+		return User{}, ErrLocked
 	}
+
 	session.Users[user.ID] = user
-	return user
+	session.logEvents(Event{Type: EventUserJoined, UserID: user.ID})
+	session.logEvents(session.ensureFacilitator()...)
+	return user.clone(), nil
+}
+
+// ClaimFacilitator makes the user the facilitator if the key is the session's creator key
+func (session *Session) ClaimFacilitator(userID, key string) bool {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	user := session.Users[userID]
+	if user == nil || !session.CheckCreatorKey(key) {
+		return false
+	}
+
+	session.logEvents(session.setFacilitator(user))
+	return true
+}
+
+// CheckCreatorKey is if the key is the session's creator key, which is never changed so it doesn't need the lock
+func (session *Session) CheckCreatorKey(key string) bool {
+	return session.CreatorKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(session.CreatorKey)) == 1
+}
+
+// CanFacilitate is if the user can show and reset results, kick users and change the stories
+func (session Session) CanFacilitate(user User) bool {
+	return user.Facilitator || session.OpenControls
+}
+
+// setFacilitator hands the facilitator role to the user
+func (session *Session) setFacilitator(user *User) Event {
+	for _, other := range session.Users {
+		other.Facilitator = false
+	}
+	user.Facilitator = true
+	return Event{Type: EventFacilitatorChanged, UserID: user.ID}
+}
+
+// ensureFacilitator makes sure someone is the facilitator, such as when the facilitator leaves the session
+func (session *Session) ensureFacilitator() []Event {
+	users := session.readyUsers()
+	for _, user := range users {
+		if user.Facilitator {
+			return nil
+		}
+	}
+
+	if len(users) == 0 {
+		return nil
+	}
+	return []Event{session.setFacilitator(session.Users[users[0].ID])}
+}
+
+// User returns a copy of the user with the ID
+func (session *Session) User(ID string) (User, bool) {
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+
+	user := session.Users[ID]
+	if user == nil {
+		return User{}, false
+	}
+	return user.clone(), true
+}
+
+// UpdateUser calls fn with the user while the session is locked. It returns false if the user doesn't exist.
+func (session *Session) UpdateUser(ID string, fn func(user *User)) bool {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	user := session.Users[ID]
+	if user == nil {
+		return false
+	}
+	fn(user)
+	return true
+}
+
+// Snapshot returns a deep copy of the session that can be read without locking, such as when rendering
+func (session *Session) Snapshot() Session {
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+
+	users := map[string]*User{}
+	for ID, user := range session.Users {
+		clone := user.clone()
+		users[ID] = &clone
+	}
+
+	var timer *Timer
+	if session.Timer != nil {
+		clone := *session.Timer
+		timer = &clone
+	}
+
+	return Session{
+		SessionInfo: session.SessionInfo,
+		ID:          session.ID,
+		Expires:     session.Expires,
+		Showing:     session.Showing,
+		Users:       users,
+		CreatorKey:  session.CreatorKey,
+		Passcode:    session.Passcode,
+		Locked:      session.Locked,
+		Stories:     slices.Clone(session.Stories),
+		History:     slices.Clone(session.History),
+		Timer:       timer,
+		revealAt:    session.revealAt,
+		lastResults: slices.Clone(session.lastResults),
+		mu:          &sync.RWMutex{},
+	}
 }
 
 func (session *Session) AllCardsSelected() bool {
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+	return session.allCardsSelected()
+}
+
+func (session *Session) allCardsSelected() bool {
 	for _, row := range session.Rows {
 		for _, user := range session.Users {
 			if user.Type == UserTypeParticipant && user.Cards[row] == "" {
@@ -86,13 +330,19 @@ func (session *Session) AllCardsSelected() bool {
 }
 
 func (session *Session) Calc() []CalcResults {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.calc()
+}
+
+func (session *Session) calc() []CalcResults {
 	if !session.Showing {
 		return nil
 	}
 
 	var results []CalcResults
 	for _, row := range session.Rows {
-		result := NewCalcResults(row)
+		result := NewCalcResults(row, session.Groups)
 
 		for _, user := range session.Users {
 			if user.Active && user.Type == UserTypeParticipant {
@@ -101,11 +351,8 @@ func (session *Session) Calc() []CalcResults {
 					return session.lastResults
 				}
 
-				if user.IsQA {
-					result.QA.Add(card)
-				} else {
-					result.Dev.Add(card)
-				}
+				value, _ := session.RowCardValue(row, card)
+				result.Groups[session.userGroup(user.Group)].Add(card, value)
 			}
 		}
 
@@ -113,21 +360,26 @@ func (session *Session) Calc() []CalcResults {
 	}
 
 	if session.MultiRow() {
-		summary := NewCalcResults("Summary")
+		summary := NewCalcResults("Summary", session.Groups)
 		for _, user := range session.Users {
 			if user.Active && user.Type == UserTypeParticipant {
+				// A special card in any row makes the whole total that card
 				value := 0.0
-				for _, card := range user.Cards {
-					amount, _ := strconv.ParseFloat(card, 64)
-					value += amount
+				cardValue := ""
+				for _, row := range session.Rows {
+					card := user.Cards[row]
+					if IsSpecialCard(card) {
+						cardValue = card
+						break
+					}
+					amount, _ := session.RowCardValue(row, card)
+					value += amount * session.RowWeight(row)
 				}
 
-				cardValue := trimFloat(value)
-				if user.IsQA {
-					summary.QA.Add(cardValue)
-				} else {
-					summary.Dev.Add(cardValue)
+				if cardValue == "" {
+					cardValue = trimFloat(value)
 				}
+				summary.Groups[session.userGroup(user.Group)].Add(cardValue, value)
 			}
 		}
 		results = append([]CalcResults{summary}, results...)
@@ -137,6 +389,36 @@ func (session *Session) Calc() []CalcResults {
 	return results
 }
 
+// CurrentStory returns the story being estimated, or nil if the queue is empty
+func (session Session) CurrentStory() *Story {
+	if len(session.Stories) == 0 {
+		return nil
+	}
+	return &session.Stories[0]
+}
+
+// CoffeeVotes is how many participants picked ☕ in any row
+func (session Session) CoffeeVotes() int {
+	votes := 0
+	for _, user := range session.Users {
+		if !user.Active || user.Type != UserTypeParticipant {
+			continue
+		}
+		for _, card := range user.Cards {
+			if card == CardCoffee {
+				votes++
+				break
+			}
+		}
+	}
+	return votes
+}
+
+// CoffeeBreak is if enough participants picked ☕ to suggest a break
+func (session Session) CoffeeBreak() bool {
+	return session.CoffeeBreakVotes > 0 && session.CoffeeVotes() >= session.CoffeeBreakVotes
+}
+
 func (session Session) MultiRow() bool {
 	return len(session.Rows) > 1
 }
@@ -148,10 +430,16 @@ type ReadyUser struct {
 }
 
 func (session *Session) ReadyUsers() []ReadyUser {
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+	return session.readyUsers()
+}
+
+func (session *Session) readyUsers() []ReadyUser {
 	var users []ReadyUser
 	for _, user := range session.Users {
 		users = append(users, ReadyUser{
-			User:        *user,
+			User:        user.clone(),
 			Ready:       len(user.Cards) == len(session.Rows),
 			Participant: user.Type == UserTypeParticipant,
 		})
@@ -183,52 +471,124 @@ func (session *Session) ReadyUsers() []ReadyUser {
 }
 
 func (session *Session) Reset() {
+	session.mu.Lock()
+	session.reset()
+	session.mu.Unlock()
+
+	session.SendUpdates()
+}
+
+// showResults shows the results and records the round in the history
+func (session *Session) showResults() {
+	session.Showing = true
+	session.History = append(session.History, session.newRound())
+	session.stopTimer()
+	session.cancelAutoReveal()
+}
+
+func (session *Session) reset() {
 	slog.Info("resetting session", "session", session.ID)
 	session.Showing = false
 	session.lastResults = nil
+	session.stopTimer()
 	for _, user := range session.Users {
 		user.Cards = map[string]string{}
 	}
-	session.SendUpdates()
 }
 
-func (session *Session) DeleteUser(ID string) {
-	user := session.Users[ID]
-	if user == nil {
-		return
+func (session *Session) SendUpdates() {
+	slog.Debug("sending session updates", "session", session.ID)
+
+	session.mu.Lock()
+	session.checkAutoReveal()
+	session.mu.Unlock()
+
+	// Collect the users first so the lock isn't held while waiting on the channels
+	session.mu.RLock()
+	var users []User
+	for _, user := range session.Users {
+		users = append(users, *user)
+	}
+	session.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, user := range users {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case user.UpdateCh <- struct{}{}:
+				slog.Debug("session update sent", "session", session.ID, "user", user.Name)
+			case <-user.closed:
+			case <-time.After(time.Millisecond * 100):
+			}
+		}()
 	}
+	wg.Wait()
+	slog.Debug("session updates done", "session", session.ID)
 
-	user.Close()
-	delete(session.Users, ID)
+	if session.onUpdate != nil {
+		session.onUpdate(session)
+	}
 }
 
-func (session *Session) SendUpdates() {
-	slog.Debug("sending session updates", "session", session.ID)
-	var wg sync.WaitGroup
+// OnUpdate sets a function that is called after every SendUpdates, which is used to persist the session
+func (session *Session) OnUpdate(fn func(*Session)) {
+	session.onUpdate = fn
+}
+
+// Restore sets up the runtime state of a session that was loaded from storage
+func (session *Session) Restore() {
+	session.mu = &sync.RWMutex{}
+	if session.Users == nil {
+		session.Users = map[string]*User{}
+	}
+	if len(session.Groups) == 0 {
+		session.Groups = DefaultGroups
+	}
 	for _, user := range session.Users {
-		if user.UpdateCh != nil {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				select {
-				case user.UpdateCh <- struct{}{}:
-					slog.Debug("session update sent", "session", session.ID, "user", user.Name)
-				case <-time.After(time.Millisecond * 100):
-				}
-			}()
+		if !slices.Contains(session.Groups, user.Group) {
+			user.Group = session.Groups[0]
+		}
+		user.Active = false
+		user.UpdateCh = make(chan struct{})
+		user.closed = make(chan struct{})
+		if user.Cards == nil {
+			user.Cards = map[string]string{}
 		}
 	}
-	wg.Wait()
-	slog.Debug("session updates done", "session", session.ID)
+	session.ensureFacilitator()
+	// A timer that ran out while the server was down only warns, since nobody saw the countdown finish
+	if session.Timer != nil && session.Timer.Ends.Before(time.Now()) {
+		session.Timer.Expired = true
+	}
+	session.armTimer()
+}
+
+// MarshalJSON locks the session so it can be stored while other goroutines are using it
+func (session *Session) MarshalJSON() ([]byte, error) {
+	type sessionJSON Session
+
+	session.mu.RLock()
+	defer session.mu.RUnlock()
+	return json.Marshal((*sessionJSON)(session))
 }
 
 func (session *Session) WrapContext(ctx context.Context) context.Context {
 	ctx, cancel := context.WithCancel(ctx)
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
 	session.cancels = append(session.cancels, cancel)
 	return ctx
 }
 
 func (session *Session) Close() {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	session.stopTimer()
+	session.cancelAutoReveal()
 	for _, cancel := range session.cancels {
 		cancel()
 	}
@@ -237,23 +597,80 @@ func (session *Session) Close() {
 	}
 }
 
+type Story struct {
+	Title       string
+	Description string
+	URL         string
+}
+
+// Round is a record of the results when they were shown
+type Round struct {
+	Time    time.Time
+	Story   *Story
+	Votes   []Vote
+	Results []CalcResults
+	// Final is the final average, which is either points or days depending on the session
+	Final float64
+}
+
+// Result is the first of the results, which has every row, or no votes if there were no results
+func (round Round) Result() CalcResults {
+	if len(round.Results) == 0 {
+		return CalcResults{}
+	}
+	return round.Results[0]
+}
+
+type Vote struct {
+	Name  string
+	Group string
+	Cards map[string]string
+}
+
+func (session *Session) newRound() Round {
+	round := Round{
+		Time:    time.Now(),
+		Results: session.calc(),
+	}
+
+	if story := session.CurrentStory(); story != nil {
+		current := *story
+		round.Story = &current
+	}
+
+	for _, user := range session.readyUsers() {
+		if user.Participant && user.Active {
+			round.Votes = append(round.Votes, Vote{Name: user.Name, Group: user.Group, Cards: user.Cards})
+		}
+	}
+
+	if len(round.Results) > 0 {
+		round.Final = session.Final(round.Results[0])
+	}
+
+	return round
+}
+
 type CalcResults struct {
-	Name    string
-	Dev, QA Distribution
+	Name string
+	// Groups are the distributions of each of the session's groups in the same order
+	Groups []Distribution
 }
 
-func NewCalcResults(name string) CalcResults {
-	results := CalcResults{
-		Name: name,
-		Dev:  NewDistribution("Dev"),
-		QA:   NewDistribution("QA"),
+func NewCalcResults(name string, groups []string) CalcResults {
+	results := CalcResults{Name: name}
+	for _, group := range groups {
+		results.Groups = append(results.Groups, NewDistribution(group))
 	}
 	return results
 }
 
+// Total pools the votes of every group into one distribution, where each vote counts once no matter its group
 func (r CalcResults) Total() Distribution {
-	total := Distribution{counts: map[string]int{}}
-
+	total := NewDistribution("Total")
+	for _, group := range r.Groups {
+		total.merge(group)
+	}
 	return total
 }
 
@@ -261,21 +678,77 @@ type Distribution struct {
 	Prefix        string
 	count, amount float64
 	counts        map[string]int
+	// values are what each counted card is worth
+	values map[string]float64
+	// special counts the special cards, which aren't part of the average
+	special map[string]int
 }
 
 func NewDistribution(prefix string) Distribution {
-	return Distribution{Prefix: prefix, counts: map[string]int{}}
+	return Distribution{Prefix: prefix, counts: map[string]int{}, values: map[string]float64{}, special: map[string]int{}}
+}
+
+type distributionJSON struct {
+	Prefix        string
+	Count, Amount float64
+	Counts        map[string]int
+	Values        map[string]float64 `json:",omitempty"`
+	Special       map[string]int     `json:",omitempty"`
+}
+
+func (d Distribution) MarshalJSON() ([]byte, error) {
+	return json.Marshal(distributionJSON{Prefix: d.Prefix, Count: d.count, Amount: d.amount, Counts: d.counts, Values: d.values, Special: d.special})
+}
+
+func (d *Distribution) UnmarshalJSON(data []byte) error {
+	var value distributionJSON
+	err := json.Unmarshal(data, &value)
+	if err != nil {
+		return err
+	}
+
+	*d = NewDistribution(value.Prefix)
+	d.count = value.Count
+	d.amount = value.Amount
+	maps.Copy(d.counts, value.Counts)
+	maps.Copy(d.values, value.Values)
+	maps.Copy(d.special, value.Special)
+	return nil
 }
 
-func (d *Distribution) Add(card string) {
-	amount, _ := strconv.ParseFloat(card, 64)
+// Add counts the card, the amount is what the card is worth when averaging. Special cards are only counted.
+func (d *Distribution) Add(card string, amount float64) {
+	if IsSpecialCard(card) {
+		d.special[card]++
+		return
+	}
 
 	d.count++
 	d.amount += amount
 	d.counts[card]++
+	d.values[card] = amount
+}
+
+// merge adds the other distribution's cards to this one
+func (d *Distribution) merge(other Distribution) {
+	d.count += other.count
+	d.amount += other.amount
+	for card, count := range other.counts {
+		d.counts[card] += count
+		d.values[card] = other.values[card]
+	}
+	for card, count := range other.special {
+		d.special[card] += count
+	}
 }
 
+// Any is if there are any votes, including special cards
 func (d Distribution) Any() bool {
+	return d.count > 0 || len(d.special) > 0
+}
+
+// Counted is if there are votes that count towards the average, which special cards don't
+func (d Distribution) Counted() bool {
 	return d.count > 0
 }
 
@@ -302,6 +775,54 @@ func (d Distribution) Distribution() string {
 	return strings.Join(counts, " ")
 }
 
+// cards are the counted cards ordered by their value
+func (d Distribution) cards() []string {
+	cards := slices.Collect(maps.Keys(d.counts))
+	slices.SortFunc(cards, func(a, b string) int {
+		return cmp.Or(cmp.Compare(d.values[a], d.values[b]), strings.Compare(a, b))
+	})
+	return cards
+}
+
+// Mode is the most picked card, with ties joined by a slash
+func (d Distribution) Mode() string {
+	most := 0
+	var modes []string
+	for _, card := range d.cards() {
+		switch count := d.counts[card]; {
+		case count > most:
+			most = count
+			modes = []string{card}
+		case count == most:
+			modes = append(modes, card)
+		}
+	}
+	return strings.Join(modes, "/")
+}
+
+// Spread is the lowest and highest card picked
+func (d Distribution) Spread() string {
+	cards := d.cards()
+	switch len(cards) {
+	case 0:
+		return ""
+	case 1:
+		return cards[0]
+	}
+	return fmt.Sprintf("%s - %s", cards[0], cards[len(cards)-1])
+}
+
+// Special is the count of each special card in the same order as the special cards
+func (d Distribution) Special() string {
+	var counts []string
+	for _, card := range SpecialCards {
+		if count := d.special[card]; count > 0 {
+			counts = append(counts, fmt.Sprintf("%s(%d)", card, count))
+		}
+	}
+	return strings.Join(counts, " ")
+}
+
 func trimFloat(f float64) string {
 	return strings.TrimRight(strings.TrimRight(strconv.FormatFloat(f, 'f', 2, 64), "0"), ".")
 }
@@ -316,22 +837,39 @@ var (
 type UserInfo struct {
 	Name string
 	Type UserType
-	IsQA bool
+	// Group is the session group the user estimates for, the first group is used if it's empty
+	Group string
 }
 
 type BaseUser struct {
 	UserInfo
-	Active bool
-	ID     string
-	Cards  map[string]string
+	Active      bool
+	ID          string
+	Facilitator bool
+	Cards       map[string]string
 }
 
 type User struct {
 	BaseUser
-	UpdateCh chan struct{}
+	UpdateCh chan struct{} `json:"-"`
+	closed   chan struct{}
+}
+
+// Closed is closed when the user is removed from the session
+func (user User) Closed() <-chan struct{} {
+	return user.closed
 }
 
 func (user *User) Close() {
 	user.Active = false
-	user.UpdateCh = nil
+	select {
+	case <-user.closed:
+	default:
+		close(user.closed)
+	}
+}
+
+func (user User) clone() User {
+	user.Cards = maps.Clone(user.Cards)
+	return user
 }
@@ -0,0 +1,316 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+)
+
+// allowedApplyErr is if the error can happen from other users changing the session at the same time
+func allowedApplyErr(err error) bool {
+	return err == nil ||
+		errors.Is(err, ErrShowing) ||
+		errors.Is(err, ErrCardsMissing) ||
+		errors.Is(err, ErrUnknownUser)
+}
+
+// TestSessionConcurrentUse runs joins, votes, resets and kicks in parallel, which is meant to be run with -race
+func TestSessionConcurrentUse(t *testing.T) {
+	info := NewSessionInfo([]string{"1", "2", "3", "5", "8"}, []string{"Frontend", "Backend"}, nil, true)
+	info.OpenControls = true
+	session := newTestSession(info)
+
+	const workers = 8
+	const rounds = 25
+
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for round := range rounds {
+				user, err := session.NewUser(fmt.Sprintf("user %d-%d", i, round), UserTypeParticipant, "", "")
+				if err != nil {
+					t.Errorf("could not add user: %v", err)
+					return
+				}
+				session.UpdateUser(user.ID, func(user *User) { user.Active = true })
+
+				commands := []Command{
+					SelectCard{Row: "Frontend", Card: "3"},
+					SelectCard{Row: "Backend", Card: "5"},
+					ShowResults{},
+					ResetResults{},
+					SelectCard{Row: "Frontend", Card: "8"},
+					UndoCard{Row: "Frontend"},
+					KickUser{UserID: user.ID},
+				}
+				for _, command := range commands {
+					_, err := session.Apply(user.ID, command)
+					if !allowedApplyErr(err) {
+						t.Errorf("could not apply %T: %v", command, err)
+					}
+
+					session.Calc()
+					session.Snapshot()
+					session.ReadyUsers()
+					session.EventsSince(0)
+				}
+			}
+		}()
+	}
+
+	// Storing the session and sending updates happen alongside the commands
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range rounds {
+			if _, err := json.Marshal(session); err != nil {
+				t.Errorf("could not marshal session: %v", err)
+			}
+			session.AllCardsSelected()
+		}
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 3 {
+			session.SendUpdates()
+		}
+	}()
+
+	wg.Wait()
+
+	if users := session.Snapshot().Users; len(users) != 0 {
+		t.Errorf("every user kicked themselves but %d are left", len(users))
+	}
+}
+
+// TestSessionCloseWhileJoining closes the session while users are joining and voting
+func TestSessionCloseWhileJoining(t *testing.T) {
+	session := newTestSession(NewSessionInfo([]string{"1", "2", "3"}, nil, nil, true))
+
+	before := newTestUser(t, session, "before", UserTypeParticipant, "")
+
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for round := range 25 {
+				user, err := session.NewUser(fmt.Sprintf("user %d-%d", i, round), UserTypeParticipant, "", "")
+				if err != nil {
+					t.Errorf("could not add user: %v", err)
+					return
+				}
+				_, err = session.Apply(user.ID, SelectCard{Card: "2"})
+				if !allowedApplyErr(err) {
+					t.Errorf("could not select card: %v", err)
+				}
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		session.WrapContext(t.Context())
+		session.Close()
+	}()
+
+	wg.Wait()
+
+	select {
+	case <-before.Closed():
+	default:
+		t.Error("user who joined before the session closed is still open")
+	}
+}
+
+func TestCalc(t *testing.T) {
+	type vote struct {
+		group string
+		cards map[string]string
+	}
+	type result struct {
+		name string
+		// groups are the average of each group, Dev then QA
+		groups  []float64
+		total   float64
+		special string
+	}
+
+	riskWeight := 2.0
+
+	tests := []struct {
+		name     string
+		rows     []string
+		rowDecks map[string]RowDeck
+		votes    []vote
+		results  []result
+		// finals are the final result of the first result for each group rule
+		finals map[GroupRule]float64
+		// recommended is the pooled final mapped to the scale
+		recommended string
+	}{
+		{
+			name: "single row",
+			votes: []vote{
+				{"Dev", map[string]string{"": "3"}},
+				{"Dev", map[string]string{"": "5"}},
+				{"QA", map[string]string{"": "8"}},
+			},
+			results: []result{
+				{name: "", groups: []float64{4, 8}, total: 16.0 / 3},
+			},
+			finals:      map[GroupRule]float64{GroupRuleSum: 12, GroupRuleMax: 8, GroupRuleAverage: 6, GroupRulePool: 16.0 / 3},
+			recommended: "5",
+		},
+		{
+			name: "multi row",
+			rows: []string{"Frontend", "Backend"},
+			votes: []vote{
+				{"Dev", map[string]string{"Frontend": "3", "Backend": "5"}},
+				{"Dev", map[string]string{"Frontend": "5", "Backend": "8"}},
+				{"QA", map[string]string{"Frontend": "2", "Backend": "1"}},
+			},
+			results: []result{
+				{name: "Summary", groups: []float64{10.5, 3}, total: 8},
+				{name: "Frontend", groups: []float64{4, 2}, total: 10.0 / 3},
+				{name: "Backend", groups: []float64{6.5, 1}, total: 14.0 / 3},
+			},
+			finals:      map[GroupRule]float64{GroupRuleSum: 13.5, GroupRuleMax: 10.5, GroupRuleAverage: 6.75, GroupRulePool: 8},
+			recommended: "8",
+		},
+		{
+			name: "single row with special card",
+			votes: []vote{
+				{"Dev", map[string]string{"": "5"}},
+				{"Dev", map[string]string{"": CardUnsure}},
+				{"QA", map[string]string{"": "8"}},
+			},
+			results: []result{
+				{name: "", groups: []float64{5, 8}, total: 6.5, special: "?(1)"},
+			},
+			finals:      map[GroupRule]float64{GroupRuleSum: 13, GroupRuleMax: 8, GroupRuleAverage: 6.5, GroupRulePool: 6.5},
+			recommended: "8",
+		},
+		{
+			name: "multi row with special card",
+			rows: []string{"Frontend", "Backend"},
+			votes: []vote{
+				{"Dev", map[string]string{"Frontend": "3", "Backend": "5"}},
+				{"Dev", map[string]string{"Frontend": CardCoffee, "Backend": "8"}},
+				{"QA", map[string]string{"Frontend": "2", "Backend": "1"}},
+			},
+			results: []result{
+				{name: "Summary", groups: []float64{8, 3}, total: 5.5, special: "☕(1)"},
+				{name: "Frontend", groups: []float64{3, 2}, total: 2.5, special: "☕(1)"},
+				{name: "Backend", groups: []float64{6.5, 1}, total: 14.0 / 3},
+			},
+			finals:      map[GroupRule]float64{GroupRuleSum: 11, GroupRuleMax: 8, GroupRuleAverage: 5.5, GroupRulePool: 5.5},
+			recommended: "5",
+		},
+		{
+			name: "group with only special cards",
+			votes: []vote{
+				{"Dev", map[string]string{"": "8"}},
+				{"QA", map[string]string{"": CardUnsure}},
+			},
+			results: []result{
+				{name: "", groups: []float64{8, 0}, total: 8, special: "?(1)"},
+			},
+			finals:      map[GroupRule]float64{GroupRuleSum: 8, GroupRuleMax: 8, GroupRuleAverage: 8, GroupRulePool: 8},
+			recommended: "8",
+		},
+		{
+			name: "weighted rows with their own deck",
+			rows: []string{"Complexity", "Risk"},
+			rowDecks: map[string]RowDeck{
+				"Risk": {Cards: []string{"low", "med", "high"}, CardValues: map[string]float64{"low": 1, "med": 2, "high": 3}, Weight: &riskWeight},
+			},
+			votes: []vote{
+				{"Dev", map[string]string{"Complexity": "3", "Risk": "high"}},
+				{"Dev", map[string]string{"Complexity": "5", "Risk": "low"}},
+				{"QA", map[string]string{"Complexity": "8", "Risk": "med"}},
+			},
+			results: []result{
+				{name: "Summary", groups: []float64{8, 12}, total: 28.0 / 3},
+				{name: "Complexity", groups: []float64{4, 8}, total: 16.0 / 3},
+				{name: "Risk", groups: []float64{2, 2}, total: 2},
+			},
+			finals:      map[GroupRule]float64{GroupRuleSum: 20, GroupRuleMax: 12, GroupRuleAverage: 10, GroupRulePool: 28.0 / 3},
+			recommended: "8",
+		},
+		{
+			name: "only special cards",
+			votes: []vote{
+				{"Dev", map[string]string{"": CardUnsure}},
+				{"QA", map[string]string{"": CardCoffee}},
+			},
+			results: []result{
+				{name: "", groups: []float64{0, 0}, total: 0, special: "?(1) ☕(1)"},
+			},
+			finals: map[GroupRule]float64{GroupRuleSum: 0, GroupRuleMax: 0, GroupRuleAverage: 0, GroupRulePool: 0},
+		},
+	}
+
+	equal := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info := NewSessionInfo([]string{"1", "2", "3", "5", "8"}, test.rows, nil, true)
+			info.SpecialCards = []string{CardUnsure, CardCoffee}
+			info.RowDecks = test.rowDecks
+			session := newTestSession(info)
+			for i, vote := range test.votes {
+				user := newTestUser(t, session, fmt.Sprintf("user %d", i), UserTypeParticipant, vote.group)
+				session.Users[user.ID].Cards = vote.cards
+			}
+			session.Showing = true
+
+			results := session.Calc()
+			if len(results) != len(test.results) {
+				t.Fatalf("got %d results, wanted %d", len(results), len(test.results))
+			}
+
+			for i, want := range test.results {
+				got := results[i]
+				if got.Name != want.name {
+					t.Errorf("result %d is %q, wanted %q", i, got.Name, want.name)
+				}
+				for j, avg := range want.groups {
+					if !equal(got.Groups[j].Avg(), avg) {
+						t.Errorf("%q %s average is %v, wanted %v", want.name, got.Groups[j].Prefix, got.Groups[j].Avg(), avg)
+					}
+				}
+
+				total := got.Total()
+				if !equal(total.Avg(), want.total) {
+					t.Errorf("%q total average is %v, wanted %v", want.name, total.Avg(), want.total)
+				}
+				if total.Special() != want.special {
+					t.Errorf("%q total special cards are %q, wanted %q", want.name, total.Special(), want.special)
+				}
+			}
+
+			for rule, final := range test.finals {
+				info := session.SessionInfo
+				info.GroupRule = rule
+				if got := info.Final(results[0]); !equal(got, final) {
+					t.Errorf("%s final is %v, wanted %v", rule, got, final)
+				}
+			}
+
+			scaled := session.SessionInfo
+			scaled.GroupRule = GroupRulePool
+			scaled.MapToScale = true
+			if got := scaled.Recommended(results[0]); got != test.recommended {
+				t.Errorf("recommended is %q, wanted %q", got, test.recommended)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+)
+
+const passcodeIterations = 600_000
+
+// Passcode is the hash of the passcode users need to join a session
+type Passcode struct {
+	Salt []byte
+	Hash []byte
+}
+
+func NewPasscode(code string) (*Passcode, error) {
+	salt := make([]byte, 16)
+	rand.Read(salt)
+
+	hash, err := pbkdf2.Key(sha256.New, code, salt, passcodeIterations, sha256.Size)
+	if err != nil {
+		return nil, err
+	}
+	return &Passcode{Salt: salt, Hash: hash}, nil
+}
+
+func (passcode Passcode) Check(code string) bool {
+	hash, err := pbkdf2.Key(sha256.New, code, passcode.Salt, passcodeIterations, sha256.Size)
+	return err == nil && hmac.Equal(hash, passcode.Hash)
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPasscodeCheck(t *testing.T) {
+	passcode, err := NewPasscode("open sesame")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !passcode.Check("open sesame") {
+		t.Error("right passcode was rejected")
+	}
+	if passcode.Check("open barley") {
+		t.Error("wrong passcode was accepted")
+	}
+}
+
+func TestJoin(t *testing.T) {
+	passcode, err := NewPasscode("open sesame")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		locked   bool
+		passcode string
+		err      error
+	}{
+		{name: "right passcode", passcode: "open sesame"},
+		{name: "wrong passcode", passcode: "open barley", err: ErrWrongPasscode},
+		{name: "locked", locked: true, passcode: "open sesame", err: ErrLocked},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			session := newTestSession(NewSessionInfo([]string{"1", "2", "3"}, nil, nil, true))
+			session.Passcode = passcode
+			session.Locked = test.locked
+
+			_, err := session.NewUser("user", UserTypeParticipant, "", test.passcode)
+			if !errors.Is(err, test.err) {
+				t.Errorf("got error %v, wanted %v", err, test.err)
+			}
+		})
+	}
+}
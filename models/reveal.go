@@ -0,0 +1,73 @@
+package models
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// maxAutoReveal is the longest grace period before the results are shown automatically
+const maxAutoReveal = time.Minute
+
+func (info SessionInfo) validateAutoReveal() error {
+	if info.AutoRevealSeconds < 0 || time.Duration(info.AutoRevealSeconds)*time.Second > maxAutoReveal {
+		return errors.New("auto reveal seconds must be between 0 and 60")
+	}
+	return nil
+}
+
+// checkAutoReveal starts the grace period once every active participant has voted,
+// and cancels it if someone undoes their vote or a participant joins before it's over
+func (session *Session) checkAutoReveal() {
+	if !session.AutoReveal || session.Showing || !session.activeVotesIn() {
+		session.cancelAutoReveal()
+		return
+	}
+	if session.reveal != nil {
+		return
+	}
+
+	delay := time.Duration(session.AutoRevealSeconds) * time.Second
+	at := time.Now().Add(delay)
+	session.revealAt = at
+	session.reveal = time.AfterFunc(delay, func() {
+		session.autoReveal(at)
+	})
+}
+
+func (session *Session) cancelAutoReveal() {
+	if session.reveal != nil {
+		session.reveal.Stop()
+		session.reveal = nil
+	}
+	session.revealAt = time.Time{}
+}
+
+// autoReveal shows the results if everyone still has a vote in when the grace period is over.
+// The reveal time makes sure a grace period that was cancelled in the meantime does nothing.
+func (session *Session) autoReveal(at time.Time) {
+	session.mu.Lock()
+	if session.reveal == nil || !session.revealAt.Equal(at) {
+		session.mu.Unlock()
+		return
+	}
+	session.reveal = nil
+	session.revealAt = time.Time{}
+
+	if !session.AutoReveal || session.Showing || !session.activeVotesIn() {
+		session.mu.Unlock()
+		return
+	}
+
+	slog.Info("everyone voted, showing results", "session", session.ID)
+	session.showResults()
+	session.logEvents(Event{Type: EventShown})
+	session.mu.Unlock()
+
+	session.SendUpdates()
+}
+
+// RevealsAt is when the results will be shown automatically, if everyone has voted and the grace period is running
+func (session Session) RevealsAt() (time.Time, bool) {
+	return session.revealAt, !session.revealAt.IsZero()
+}
@@ -0,0 +1,160 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// RowDeck is a row's own cards and how much the row counts towards the summary
+type RowDeck struct {
+	// Deck is the ID of the deck the cards are from, it's empty for custom cards
+	Deck       string             `json:",omitempty"`
+	Cards      []string           `json:",omitempty"`
+	CardValues map[string]float64 `json:",omitempty"`
+	// Weight multiplies the row's votes when adding up the summary, it's 1 if nil
+	Weight *float64 `json:",omitempty"`
+}
+
+// ResolveRowDecks fills in the cards of row decks that use a deck preset
+func (info *SessionInfo) ResolveRowDecks() error {
+	for row, rowDeck := range info.RowDecks {
+		if rowDeck.Deck == "" {
+			continue
+		}
+
+		deck, ok := GetDeck(rowDeck.Deck)
+		if !ok {
+			return fmt.Errorf("unknown deck %q for row %q", rowDeck.Deck, row)
+		}
+		rowDeck.Cards = deck.Cards
+		rowDeck.CardValues = deck.Values
+		info.RowDecks[row] = rowDeck
+	}
+	return nil
+}
+
+func (info SessionInfo) validateRowDecks() error {
+	for row, rowDeck := range info.RowDecks {
+		if !slices.Contains(info.Rows, row) {
+			return fmt.Errorf("row %q is not in the session", row)
+		}
+		for _, card := range rowDeck.Cards {
+			if IsSpecialCard(card) {
+				return fmt.Errorf("card %q of row %q is a special card", card, row)
+			}
+			if _, ok := cardValue(rowDeck.CardValues, card); !ok {
+				return fmt.Errorf("card %q of row %q is not a number", card, row)
+			}
+		}
+		if rowDeck.Weight != nil && (*rowDeck.Weight < 0 || math.IsInf(*rowDeck.Weight, 0) || math.IsNaN(*rowDeck.Weight)) {
+			return fmt.Errorf("weight of row %q must be a non-negative number", row)
+		}
+	}
+	return nil
+}
+
+// RowCards are the cards users can pick in the row, which are the row's cards or the session's, followed by the special cards
+func (info SessionInfo) RowCards(row string) []string {
+	if cards := info.RowDecks[row].Cards; len(cards) > 0 {
+		return slices.Concat(cards, info.SpecialCards)
+	}
+	return info.DeckCards()
+}
+
+// RowCardValue is the number the card counts as in the row
+func (info SessionInfo) RowCardValue(row, card string) (float64, bool) {
+	if len(info.RowDecks[row].Cards) > 0 {
+		return cardValue(info.RowDecks[row].CardValues, card)
+	}
+	return info.CardValue(card)
+}
+
+// RowWeight is how much the row counts towards the summary
+func (info SessionInfo) RowWeight(row string) float64 {
+	if weight := info.RowDecks[row].Weight; weight != nil {
+		return *weight
+	}
+	return 1
+}
+
+// ParseRowDecks parses a line per row formatted as row|cards|weight. The cards are a deck ID,
+// or a comma delimited list of numbers or card=number, and are the session's cards if empty.
+// The weight is 1 if it's left out.
+func ParseRowDecks(text string) (map[string]RowDeck, error) {
+	rowDecks := map[string]RowDeck{}
+	for line := range strings.Lines(text) {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		fields := strings.Split(line, "|")
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("row deck %q is not row|cards|weight", strings.TrimSpace(line))
+		}
+		fields = append(fields, "", "")
+
+		row := strings.TrimSpace(fields[0])
+		var rowDeck RowDeck
+		cards := strings.TrimSpace(fields[1])
+		switch _, isDeck := GetDeck(cards); {
+		case isDeck:
+			rowDeck.Deck = cards
+		case cards != "":
+			rowDeck.CardValues = map[string]float64{}
+			for card := range strings.SplitSeq(cards, ",") {
+				card, value, hasValue := strings.Cut(card, "=")
+				card = strings.TrimSpace(card)
+				if IsSpecialCard(card) {
+					return nil, fmt.Errorf("card %q of row %q is a special card", card, row)
+				}
+				if hasValue {
+					number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+					if err != nil || math.IsInf(number, 0) || math.IsNaN(number) {
+						return nil, fmt.Errorf("card value %q of row %q is not a number", value, row)
+					}
+					rowDeck.CardValues[card] = number
+				}
+				rowDeck.Cards = append(rowDeck.Cards, card)
+			}
+		}
+
+		if weight := strings.TrimSpace(fields[2]); weight != "" {
+			number, err := strconv.ParseFloat(weight, 64)
+			if err != nil || math.IsInf(number, 0) || math.IsNaN(number) {
+				return nil, fmt.Errorf("weight %q of row %q is not a number", weight, row)
+			}
+			rowDeck.Weight = &number
+		}
+
+		rowDecks[row] = rowDeck
+	}
+	return rowDecks, nil
+}
+
+// FormatRowDecks formats the row decks the same as ParseRowDecks parses them, in the order of the rows
+func (info SessionInfo) FormatRowDecks() string {
+	var lines []string
+	for _, row := range info.Rows {
+		rowDeck, ok := info.RowDecks[row]
+		if !ok {
+			continue
+		}
+
+		cards := rowDeck.Deck
+		if cards == "" {
+			var values []string
+			for _, card := range rowDeck.Cards {
+				if value, ok := rowDeck.CardValues[card]; ok {
+					card = fmt.Sprintf("%s=%s", card, trimFloat(value))
+				}
+				values = append(values, card)
+			}
+			cards = strings.Join(values, ",")
+		}
+		lines = append(lines, fmt.Sprintf("%s|%s|%s", row, cards, trimFloat(info.RowWeight(row))))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseRowDecks(t *testing.T) {
+	weight := 2.0
+
+	tests := []struct {
+		name     string
+		text     string
+		rowDecks map[string]RowDeck
+		err      bool
+	}{
+		{name: "empty", text: "", rowDecks: map[string]RowDeck{}},
+		{
+			name: "cards and weight",
+			text: "Risk|low=1, high=3|2\nEffort|1,2\n",
+			rowDecks: map[string]RowDeck{
+				"Risk":   {Cards: []string{"low", "high"}, CardValues: map[string]float64{"low": 1, "high": 3}, Weight: &weight},
+				"Effort": {Cards: []string{"1", "2"}, CardValues: map[string]float64{}},
+			},
+		},
+		{name: "too many fields", text: "Risk|1|2|3", err: true},
+		{name: "card value isn't a number", text: "Risk|low=some", err: true},
+		{name: "weight isn't a number", text: "Risk|1|heavy", err: true},
+		{name: "special card", text: "Risk|low=1,?=2", err: true},
+		{name: "special card without a value", text: "Risk|1," + CardCoffee, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rowDecks, err := ParseRowDecks(test.text)
+			if (err != nil) != test.err {
+				t.Fatalf("error is %v, wanted an error %t", err, test.err)
+			}
+			if !test.err && !reflect.DeepEqual(rowDecks, test.rowDecks) {
+				t.Errorf("row decks are %+v, wanted %+v", rowDecks, test.rowDecks)
+			}
+		})
+	}
+}
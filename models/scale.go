@@ -0,0 +1,125 @@
+package models
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Rounding is how the final result is rounded to a value on the scale
+type Rounding string
+
+var (
+	RoundNearest Rounding = "nearest"
+	RoundUp      Rounding = "up"
+	RoundDown    Rounding = "down"
+)
+
+var Roundings = []Rounding{RoundNearest, RoundUp, RoundDown}
+
+type scalePoint struct {
+	Label string
+	Value float64
+}
+
+// ParseScale parses a comma delimited list of numbers, an empty list uses the deck
+func ParseScale(text string) ([]float64, error) {
+	var scale []float64
+	for number := range strings.SplitSeq(text, ",") {
+		number = strings.TrimSpace(number)
+		if number == "" {
+			continue
+		}
+
+		value, err := strconv.ParseFloat(number, 64)
+		if err != nil || math.IsInf(value, 0) || math.IsNaN(value) {
+			return nil, fmt.Errorf("scale value %q is not a number", number)
+		}
+		scale = append(scale, value)
+	}
+	return scale, nil
+}
+
+// scale is the sorted points the final result is mapped to, which are the cards if the session has no scale
+func (info SessionInfo) scale() []scalePoint {
+	var points []scalePoint
+	if len(info.Scale) > 0 {
+		for _, value := range info.Scale {
+			points = append(points, scalePoint{Label: trimFloat(value), Value: value})
+		}
+	} else {
+		for _, card := range info.Cards {
+			if value, ok := info.CardValue(card); ok {
+				points = append(points, scalePoint{Label: card, Value: value})
+			}
+		}
+	}
+
+	slices.SortStableFunc(points, func(a, b scalePoint) int { return cmp.Compare(a.Value, b.Value) })
+	return slices.CompactFunc(points, func(a, b scalePoint) bool { return a.Value == b.Value })
+}
+
+// MapsResult is if the final result is mapped to the scale instead of being shown as is
+func (info SessionInfo) MapsResult() bool {
+	return info.Sizes || info.MapToScale
+}
+
+// FinalRange returns the points on the scale the final result falls between
+func (info SessionInfo) FinalRange(final float64) string {
+	points := info.scale()
+	if !info.MapsResult() || len(points) == 0 {
+		return ""
+	}
+
+	for i, point := range points {
+		switch {
+		case point.Value == final:
+			return point.Label
+		case point.Value > final && i == 0:
+			return fmt.Sprintf("X < %s", point.Label)
+		case point.Value > final:
+			return fmt.Sprintf("%s - %s", points[i-1].Label, point.Label)
+		}
+	}
+	return fmt.Sprintf("%s < X", points[len(points)-1].Label)
+}
+
+// Recommended rounds the final result to a point on the scale with the session's rounding.
+// There's no recommendation when no vote counts, like when everyone picked a special card.
+func (info SessionInfo) Recommended(result CalcResults) string {
+	points := info.scale()
+	if !info.MapsResult() || len(points) == 0 || !result.Total().Counted() {
+		return ""
+	}
+
+	final := info.Final(result)
+
+	switch info.Rounding {
+	case RoundUp:
+		for _, point := range points {
+			if point.Value >= final {
+				return point.Label
+			}
+		}
+		return points[len(points)-1].Label
+	case RoundDown:
+		for _, point := range slices.Backward(points) {
+			if point.Value <= final {
+				return point.Label
+			}
+		}
+		return points[0].Label
+	}
+
+	// Halfway between points rounds up
+	nearest := points[0]
+	for _, point := range points[1:] {
+		if math.Abs(point.Value-final) <= math.Abs(nearest.Value-final) {
+			nearest = point
+		}
+	}
+	return nearest.Label
+}
@@ -0,0 +1,95 @@
+package models
+
+import (
+	"math"
+	"slices"
+)
+
+// Aggregation is how a distribution is turned into the number used for the final result
+type Aggregation string
+
+var (
+	AggregateMean   Aggregation = "mean"
+	AggregateMedian Aggregation = "median"
+	AggregateMode   Aggregation = "mode"
+)
+
+var Aggregations = []Aggregation{AggregateMean, AggregateMedian, AggregateMode}
+
+// amounts are the sorted amounts of every counted card
+func (d Distribution) amounts() []float64 {
+	var amounts []float64
+	for card, count := range d.counts {
+		for range count {
+			amounts = append(amounts, d.values[card])
+		}
+	}
+	slices.Sort(amounts)
+	return amounts
+}
+
+func (d Distribution) Median() float64 {
+	amounts := d.amounts()
+	if len(amounts) == 0 {
+		return 0
+	}
+
+	middle := len(amounts) / 2
+	if len(amounts)%2 == 0 {
+		return (amounts[middle-1] + amounts[middle]) / 2
+	}
+	return amounts[middle]
+}
+
+// ModeAmount is the amount of the most picked card, taking the highest when there is a tie
+func (d Distribution) ModeAmount() float64 {
+	most, mode := 0, 0.0
+	for _, card := range d.cards() {
+		if count := d.counts[card]; count >= most {
+			most = count
+			mode = d.values[card]
+		}
+	}
+	return mode
+}
+
+func (d Distribution) Min() float64 {
+	amounts := d.amounts()
+	if len(amounts) == 0 {
+		return 0
+	}
+	return amounts[0]
+}
+
+func (d Distribution) Max() float64 {
+	amounts := d.amounts()
+	if len(amounts) == 0 {
+		return 0
+	}
+	return amounts[len(amounts)-1]
+}
+
+// StdDev is the population standard deviation of the amounts
+func (d Distribution) StdDev() float64 {
+	if d.count == 0 {
+		return 0
+	}
+
+	avg := d.Avg()
+	variance := 0.0
+	for _, amount := range d.amounts() {
+		variance += (amount - avg) * (amount - avg)
+	}
+	return math.Sqrt(variance / d.count)
+}
+
+// Aggregate returns the average, median or mode amount
+func (d Distribution) Aggregate(aggregation Aggregation) float64 {
+	switch aggregation {
+	case AggregateMedian:
+		return d.Median()
+	case AggregateMode:
+		return d.ModeAmount()
+	}
+	return d.Avg()
+}
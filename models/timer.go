@@ -0,0 +1,165 @@
+package models
+
+import (
+	"errors"
+	"log/slog"
+	"time"
+)
+
+var ErrInvalidTimer = errors.New("timer must be between 1 second and 1 hour")
+
+// DefaultTimerSeconds is used when neither the command nor the session has a timer duration
+const DefaultTimerSeconds = 120
+
+// maxTimer is the longest a round timer can run
+const maxTimer = time.Hour
+
+var (
+	EventTimerStarted EventType = "timerStarted"
+	EventTimerStopped EventType = "timerStopped"
+	EventTimerExpired EventType = "timerExpired"
+)
+
+// Timer is a countdown for the current round that every client shows
+type Timer struct {
+	Ends time.Time
+	// Expired is set when the timer ran out without the results being shown
+	Expired bool
+}
+
+// Remaining is how long is left on the timer, it's never negative
+func (timer Timer) Remaining(now time.Time) time.Duration {
+	return max(timer.Ends.Sub(now), 0)
+}
+
+func (info SessionInfo) validateTimer() error {
+	if info.TimerSeconds < 0 || time.Duration(info.TimerSeconds)*time.Second > maxTimer {
+		return errors.New("timer seconds must be between 0 and 1 hour")
+	}
+	return nil
+}
+
+// TimerDuration is how long a timer runs when the facilitator doesn't pick a duration
+func (info SessionInfo) TimerDuration() time.Duration {
+	return info.timerDuration(0)
+}
+
+// timerDuration is the seconds if set, then the session's timer duration, then the default
+func (info SessionInfo) timerDuration(seconds int) time.Duration {
+	if seconds == 0 {
+		seconds = info.TimerSeconds
+	}
+	if seconds == 0 {
+		seconds = DefaultTimerSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// startTimer replaces any running timer with one that ends after the duration
+func (session *Session) startTimer(duration time.Duration) {
+	session.stopTimer()
+
+	ends := time.Now().Add(duration)
+	session.Timer = &Timer{Ends: ends}
+	session.armTimer()
+}
+
+// armTimer schedules the expiry of the session's timer, which is needed again after a restore
+func (session *Session) armTimer() {
+	if session.Timer == nil || session.Timer.Expired {
+		return
+	}
+
+	ends := session.Timer.Ends
+	session.timer = time.AfterFunc(time.Until(ends), func() {
+		session.expireTimer(ends)
+	})
+}
+
+func (session *Session) stopTimer() {
+	if session.timer != nil {
+		session.timer.Stop()
+		session.timer = nil
+	}
+	session.Timer = nil
+}
+
+// activeVotesIn is if there is an active participant and all of them have picked a card in every row
+func (session *Session) activeVotesIn() bool {
+	participants := 0
+	for _, user := range session.Users {
+		if !user.Active || user.Type != UserTypeParticipant {
+			continue
+		}
+		participants++
+		for _, row := range session.Rows {
+			if user.Cards[row] == "" {
+				return false
+			}
+		}
+	}
+	return participants > 0
+}
+
+// expireTimer shows the results if the session auto reveals and every active participant has voted,
+// otherwise it flags the timer as expired. Disconnected participants don't hold up the reveal since they aren't in the results.
+// The end time makes sure a timer that was replaced or stopped in the meantime does nothing.
+func (session *Session) expireTimer(ends time.Time) {
+	session.mu.Lock()
+	if session.Timer == nil || session.Timer.Expired || !session.Timer.Ends.Equal(ends) {
+		session.mu.Unlock()
+		return
+	}
+
+	session.timer = nil
+	events := []Event{{Type: EventTimerExpired}}
+	if session.TimerAutoReveal && !session.Showing && session.activeVotesIn() {
+		slog.Info("timer expired, showing results", "session", session.ID)
+		session.showResults()
+		events = append(events, Event{Type: EventShown})
+	} else {
+		slog.Info("timer expired", "session", session.ID)
+		session.Timer.Expired = true
+	}
+	session.logEvents(events...)
+	session.mu.Unlock()
+
+	session.SendUpdates()
+}
+
+// StartTimer starts a countdown for the round, zero seconds uses the session's timer duration
+type StartTimer struct {
+	Seconds int
+}
+
+func (c StartTimer) validate(session *Session, user *User) error {
+	if err := session.validateFacilitator(user); err != nil {
+		return err
+	}
+	if session.Showing {
+		return ErrShowing
+	}
+	if c.Seconds < 0 || session.timerDuration(c.Seconds) > maxTimer {
+		return ErrInvalidTimer
+	}
+	return nil
+}
+
+func (c StartTimer) apply(session *Session, user *User) []Event {
+	duration := session.timerDuration(c.Seconds)
+	slog.Info("starting timer", "session", session.ID, "user", user.Name, "duration", duration)
+	session.startTimer(duration)
+	return []Event{{Type: EventTimerStarted, UserID: user.ID}}
+}
+
+// StopTimer stops the countdown and clears an expired warning
+type StopTimer struct{}
+
+func (c StopTimer) validate(session *Session, user *User) error {
+	return session.validateFacilitator(user)
+}
+
+func (c StopTimer) apply(session *Session, user *User) []Event {
+	session.stopTimer()
+	return []Event{{Type: EventTimerStopped, UserID: user.ID}}
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimerExpiry(t *testing.T) {
+	tests := []struct {
+		name       string
+		autoReveal bool
+		// vote is if the connected participant votes, the disconnected one never does
+		vote    bool
+		showing bool
+	}{
+		{name: "auto reveal", autoReveal: true, vote: true, showing: true},
+		{name: "auto reveal with votes missing", autoReveal: true},
+		{name: "warning only", vote: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			info := NewSessionInfo([]string{"1", "2", "3"}, nil, nil, true)
+			info.TimerAutoReveal = test.autoReveal
+			session := newTestSession(info)
+			defer session.Close()
+
+			connected := newTestUser(t, session, "connected", UserTypeParticipant, "")
+			disconnected := newTestUser(t, session, "disconnected", UserTypeParticipant, "")
+			session.UpdateUser(disconnected.ID, func(user *User) { user.Active = false })
+			if test.vote {
+				if _, err := session.Apply(connected.ID, SelectCard{Card: "2"}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if _, err := session.Apply(connected.ID, StartTimer{Seconds: 60}); err != nil {
+				t.Fatal(err)
+			}
+			session.expireTimer(session.Snapshot().Timer.Ends)
+
+			snapshot := session.Snapshot()
+			if snapshot.Showing != test.showing {
+				t.Errorf("showing is %t, wanted %t", snapshot.Showing, test.showing)
+			}
+			if test.showing && snapshot.Timer != nil {
+				t.Error("timer is still set after the results were shown")
+			}
+			if !test.showing && (snapshot.Timer == nil || !snapshot.Timer.Expired) {
+				t.Errorf("timer is %+v, wanted it expired", snapshot.Timer)
+			}
+		})
+	}
+}
+
+func TestStaleTimerDoesNothing(t *testing.T) {
+	session := newTestSession(NewSessionInfo([]string{"1", "2", "3"}, nil, nil, true))
+	defer session.Close()
+	user := newTestUser(t, session, "user", UserTypeParticipant, "")
+
+	if _, err := session.Apply(user.ID, StartTimer{Seconds: 60}); err != nil {
+		t.Fatal(err)
+	}
+	stale := session.Snapshot().Timer.Ends
+	time.Sleep(time.Millisecond)
+	if _, err := session.Apply(user.ID, StartTimer{Seconds: 60}); err != nil {
+		t.Fatal(err)
+	}
+
+	session.expireTimer(stale)
+	if timer := session.Snapshot().Timer; timer == nil || timer.Expired {
+		t.Errorf("replaced timer expired the new one: %+v", timer)
+	}
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/angelofallars/htmx-go"
+	"github.com/joeyak/scrum-poker/components"
+)
+
+const (
+	oidcClientSecretEnv = "SCRUM_POKER_OIDC_CLIENT_SECRET"
+
+	identityCookieName = "identity"
+	loginCookieName    = "oidc-login"
+	callbackPath       = "/auth/callback"
+)
+
+// oidc is the OpenID Connect provider users log in with, it's nil if logging in isn't configured
+var oidc *oidcProvider
+
+// identity is the logged in user, which is kept in a signed cookie
+type identity struct {
+	Subject string
+	Name    string
+}
+
+// oidcLogin is what's needed to finish a login once the provider redirects back
+type oidcLogin struct {
+	State, Nonce, Verifier string
+	RedirectURL            string
+	Next                   string
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// audience is a string or a list of strings in a token
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*a = audience{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+type idTokenClaims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	Expires           int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce,omitempty"`
+	Name              string   `json:"name,omitempty"`
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+	Email             string   `json:"email,omitempty"`
+}
+
+// displayName picks the best name from the claims to use as the user name
+func (claims idTokenClaims) displayName() string {
+	for _, name := range []string{claims.Name, claims.PreferredUsername, claims.Email} {
+		if strings.TrimSpace(name) != "" {
+			return strings.TrimSpace(name)
+		}
+	}
+	return claims.Subject
+}
+
+type oidcProvider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	// redirectURL is where the provider sends users back to, it's made from the request if empty
+	redirectURL string
+	// requiredToCreate only lets logged in users create sessions
+	requiredToCreate bool
+
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery
+	keys      map[string]*rsa.PublicKey
+}
+
+func newOidcProvider(issuer, clientID, clientSecret, redirectURL string, requiredToCreate bool) *oidcProvider {
+	return &oidcProvider{
+		issuer:           strings.TrimRight(issuer, "/"),
+		clientID:         clientID,
+		clientSecret:     clientSecret,
+		redirectURL:      redirectURL,
+		requiredToCreate: requiredToCreate,
+		client:           &http.Client{Timeout: time.Second * 10},
+		keys:             map[string]*rsa.PublicKey{},
+	}
+}
+
+func (p *oidcProvider) getJson(url string, value any) error {
+	resp, err := p.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(value)
+}
+
+// getDiscovery loads the provider's configuration the first time it's needed, since the provider might not be up when the server starts
+func (p *oidcProvider) getDiscovery() (oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return *p.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	err := p.getJson(p.issuer+"/.well-known/openid-configuration", &discovery)
+	if err != nil {
+		return oidcDiscovery{}, fmt.Errorf("could not get discovery document: %w", err)
+	}
+	if discovery.Issuer != p.issuer {
+		return oidcDiscovery{}, fmt.Errorf("discovery issuer %q does not match %q", discovery.Issuer, p.issuer)
+	}
+
+	p.discovery = &discovery
+	return discovery, nil
+}
+
+// key gets the signing key with the ID, fetching the keys again if it's unknown in case the provider rotated them
+func (p *oidcProvider) key(discovery oidcDiscovery, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+
+	var set jsonWebKeySet
+	err := p.getJson(discovery.JwksURI, &set)
+	if err != nil {
+		return nil, fmt.Errorf("could not get keys: %w", err)
+	}
+
+	p.keys = map[string]*rsa.PublicKey{}
+	for _, jwk := range set.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil {
+			slog.Warn("skipping invalid json web key", "kid", jwk.Kid)
+			continue
+		}
+
+		p.keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	key, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
+
+// verifyIDToken checks the signature and claims of an RS256 id token
+func (p *oidcProvider) verifyIDToken(discovery oidcDiscovery, token, nonce string) (idTokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return idTokenClaims{}, errors.New("id token is not a jwt")
+	}
+
+	var header jwtHeader
+	err := decodeJwtPart(parts[0], &header)
+	if err != nil {
+		return idTokenClaims{}, fmt.Errorf("could not decode header: %w", err)
+	}
+	if header.Alg != "RS256" {
+		return idTokenClaims{}, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+
+	key, err := p.key(discovery, header.Kid)
+	if err != nil {
+		return idTokenClaims{}, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return idTokenClaims{}, fmt.Errorf("could not decode signature: %w", err)
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature)
+	if err != nil {
+		return idTokenClaims{}, fmt.Errorf("invalid signature: %w", err)
+	}
+
+	var claims idTokenClaims
+	err = decodeJwtPart(parts[1], &claims)
+	if err != nil {
+		return idTokenClaims{}, fmt.Errorf("could not decode claims: %w", err)
+	}
+
+	switch {
+	case claims.Issuer != p.issuer:
+		return idTokenClaims{}, fmt.Errorf("issuer %q does not match", claims.Issuer)
+	case !slices.Contains(claims.Audience, p.clientID):
+		return idTokenClaims{}, errors.New("token is not for this client")
+	case time.Now().After(time.Unix(claims.Expires, 0)):
+		return idTokenClaims{}, errors.New("token is expired")
+	case claims.Nonce != nonce:
+		return idTokenClaims{}, errors.New("nonce does not match")
+	case claims.Subject == "":
+		return idTokenClaims{}, errors.New("token has no subject")
+	}
+
+	return claims, nil
+}
+
+// exchange trades the authorization code and pkce verifier for the id token
+func (p *oidcProvider) exchange(discovery oidcDiscovery, login oidcLogin, code string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {login.RedirectURL},
+		"client_id":     {p.clientID},
+		"code_verifier": {login.Verifier},
+	}
+
+	req, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return "", fmt.Errorf("could not decode token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint returned %s: %s %s", resp.Status, token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return "", errors.New("token response has no id token")
+	}
+	return token.IDToken, nil
+}
+
+func (p *oidcProvider) callbackURL(r *http.Request) string {
+	if p.redirectURL != "" {
+		return p.redirectURL
+	}
+
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, r.Host, callbackPath)
+}
+
+func decodeJwtPart(part string, value any) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, value)
+}
+
+func randomString() string {
+	buff := make([]byte, 32)
+	rand.Read(buff)
+	return base64.RawURLEncoding.EncodeToString(buff)
+}
+
+func pkceChallenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func setJsonCookie(w http.ResponseWriter, cookie *http.Cookie, value any) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		slog.Error("could not marshal cookie", "cookie", cookie.Name, "err", err)
+		return
+	}
+	cookie.Value = base64.RawURLEncoding.EncodeToString(data)
+	setSignedCookie(w, cookie)
+}
+
+func readJsonCookie(r *http.Request, name string, value any) bool {
+	signed, ok := readSignedCookie(r, name)
+	if !ok {
+		return false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(signed)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(data, value) == nil
+}
+
+// requestIdentity is the logged in user making the request
+func requestIdentity(r *http.Request) (identity, bool) {
+	if oidc == nil {
+		return identity{}, false
+	}
+
+	var id identity
+	ok := readJsonCookie(r, identityCookieName, &id)
+	return id, ok && id.Subject != ""
+}
+
+// loginMiddleware puts the login state in the context for the templates
+func loginMiddleware(handler http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if oidc != nil {
+			login := components.Login{Enabled: true, RequiredToCreate: oidc.requiredToCreate}
+			if id, ok := requestIdentity(r); ok {
+				login.Name = id.Name
+			}
+			r = r.WithContext(components.WithLogin(r.Context(), login))
+		}
+		handler(w, r)
+	})
+}
+
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	if oidc == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	discovery, err := oidc.getDiscovery()
+	if err != nil {
+		slog.Error("could not start login", "err", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	// Only redirect back to this site
+	next := r.URL.Query().Get("next")
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		next = "/"
+	}
+
+	login := oidcLogin{
+		State:       randomString(),
+		Nonce:       randomString(),
+		Verifier:    randomString(),
+		RedirectURL: oidc.callbackURL(r),
+		Next:        next,
+	}
+	setJsonCookie(w, &http.Cookie{
+		Name:     loginCookieName,
+		Path:     callbackPath,
+		MaxAge:   int((time.Minute * 10).Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}, login)
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {oidc.clientID},
+		"redirect_uri":          {login.RedirectURL},
+		"scope":                 {"openid profile email"},
+		"state":                 {login.State},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {pkceChallenge(login.Verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	authURL := discovery.AuthorizationEndpoint
+	if strings.Contains(authURL, "?") {
+		authURL += "&" + query.Encode()
+	} else {
+		authURL += "?" + query.Encode()
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func handleAuthCallback(w http.ResponseWriter, r *http.Request) {
+	if oidc == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var login oidcLogin
+	if !readJsonCookie(r, loginCookieName, &login) {
+		slog.Warn("login callback without a login cookie")
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: loginCookieName, Path: callbackPath, MaxAge: -1})
+
+	query := r.URL.Query()
+	if query.Get("state") != login.State {
+		slog.Warn("login callback state does not match")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if errorCode := query.Get("error"); errorCode != "" {
+		slog.Warn("login failed at the provider", "error", errorCode, "description", query.Get("error_description"))
+		http.Redirect(w, r, login.Next, http.StatusFound)
+		return
+	}
+
+	discovery, err := oidc.getDiscovery()
+	if err != nil {
+		slog.Error("could not finish login", "err", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	idToken, err := oidc.exchange(discovery, login, query.Get("code"))
+	if err != nil {
+		slog.Error("could not exchange login code", "err", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	claims, err := oidc.verifyIDToken(discovery, idToken, login.Nonce)
+	if err != nil {
+		slog.Warn("invalid id token", "err", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	id := identity{Subject: claims.Subject, Name: claims.displayName()}
+	slog.Info("user logged in", "subject", id.Subject, "name", id.Name)
+	setJsonCookie(w, &http.Cookie{
+		Name:     identityCookieName,
+		Path:     "/",
+		Expires:  time.Now().Add(time.Hour * 24),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}, id)
+
+	http.Redirect(w, r, login.Next, http.StatusFound)
+}
+
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if oidc == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{Name: identityCookieName, Path: "/", MaxAge: -1})
+	if htmx.IsHTMX(r) {
+		w.Header().Set("HX-Redirect", "/")
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/joeyak/scrum-poker/components"
+)
+
+const openAPIFile = "static/openapi.json"
+
+func openAPISpec() []byte {
+	spec, err := staticFS.ReadFile(openAPIFile)
+	if err != nil {
+		panic(fmt.Sprintf("could not read %s: %s", openAPIFile, err))
+	}
+	return spec
+}
+
+// CheckOpenAPI makes sure every documented pattern is in the spec and every operation in the spec has a pattern
+func (h Handler) CheckOpenAPI(spec []byte) error {
+	var document struct {
+		Paths map[string]map[string]json.RawMessage
+	}
+	err := json.Unmarshal(spec, &document)
+	if err != nil {
+		return fmt.Errorf("could not unmarshal spec: %w", err)
+	}
+
+	specMethods := map[string][]string{}
+	for path, item := range document.Paths {
+		for key := range item {
+			method := strings.ToUpper(key)
+			switch method {
+			case http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete, http.MethodOptions, http.MethodHead, http.MethodPatch, http.MethodTrace:
+				specMethods[path] = append(specMethods[path], method)
+			}
+		}
+	}
+
+	routeMethods := map[string][]string{}
+	var errs []error
+	for _, pattern := range h.documentedPatterns {
+		method, path, found := strings.Cut(pattern, " ")
+		if !found {
+			method, path = "", pattern
+		}
+		routeMethods[path] = append(routeMethods[path], method)
+
+		methods, ok := specMethods[path]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%q is missing from the spec", pattern))
+		} else if method != "" && !slices.Contains(methods, method) {
+			errs = append(errs, fmt.Errorf("%q is missing method %s in the spec", path, method))
+		}
+	}
+
+	for _, path := range slices.Sorted(maps.Keys(specMethods)) {
+		methods := specMethods[path]
+		routes, ok := routeMethods[path]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%q in the spec has no route", path))
+			continue
+		}
+
+		// A route without a method handles every method
+		if slices.Contains(routes, "") {
+			continue
+		}
+
+		for _, method := range methods {
+			if !slices.Contains(routes, method) {
+				errs = append(errs, fmt.Errorf("%s %q in the spec has no route", method, path))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec())
+}
+
+func handleApiDocs(w http.ResponseWriter, r *http.Request) {
+	err := components.ApiDocs("/openapi.json").Render(r.Context(), w)
+	if err != nil {
+		slog.Error("could not render api docs", "err", err)
+	}
+}
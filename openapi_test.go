@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRoutesMatchOpenAPI(t *testing.T) {
+	idp, err := newFakeIdP("http://localhost:8080/fake-idp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, idp := range map[string]*fakeIdP{"without fake idp": nil, "with fake idp": idp} {
+		t.Run(name, func(t *testing.T) {
+			handler, err := newHandler(false, idp)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = handler.CheckOpenAPI(openAPISpec())
+			if err != nil {
+				t.Errorf("routes and openapi spec do not match: %v", err)
+			}
+		})
+	}
+}
+
+func TestCheckOpenAPIFindsMissingRoutes(t *testing.T) {
+	handler, err := newHandler(false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.HandleFunc("GET /api/v1/undocumented", func(w http.ResponseWriter, r *http.Request) {})
+
+	if handler.CheckOpenAPI(openAPISpec()) == nil {
+		t.Error("undocumented route was not found")
+	}
+	if handler.CheckOpenAPI([]byte(`{"paths": {}}`)) == nil {
+		t.Error("routes missing from the spec were not found")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/joeyak/scrum-poker/components"
+	"github.com/joeyak/scrum-poker/models"
+)
+
+func actionLink(session *models.Session, userID string) string {
+	return fmt.Sprintf("/session/%s/user/%s/action", session.ID, userID)
+}
+
+// writeSSE writes the data as a server sent event, prefixing every line since data can't have new lines
+func writeSSE(w io.Writer, event string, data []byte) error {
+	var buff bytes.Buffer
+	fmt.Fprintf(&buff, "event: %s\n", event)
+	for line := range strings.SplitSeq(string(data), "\n") {
+		fmt.Fprintf(&buff, "data: %s\n", line)
+	}
+	buff.WriteString("\n")
+
+	_, err := w.Write(buff.Bytes())
+	return err
+}
+
+func handleUserSse(w http.ResponseWriter, r *http.Request) {
+	session := sessionManager.Get(r.PathValue("sessionID"))
+	if session == nil {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	user, ok := session.User(r.PathValue("userID"))
+	if !ok {
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	if callerID, ok := requestUserID(r, session); !ok || callerID != user.ID {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		slog.Error("response writer does not support flushing for server sent events")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	defer sessionManager.Cleanup()
+
+	logAttrs := slog.Group("", slog.String("session", session.ID), slog.String("user", user.Name))
+	defer func() {
+		slog.Debug("sse connection closing", logAttrs)
+		session.UpdateUser(user.ID, func(user *models.User) { user.Active = false })
+		session.SendUpdates()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stop nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx := components.WithActionURL(r.Context(), actionLink(session, user.ID))
+
+	send := func(render func(ctx context.Context, w io.Writer) error) {
+		var buff bytes.Buffer
+		err := render(ctx, &buff)
+		if err != nil {
+			slog.Error("could not render sse message", logAttrs, "err", err)
+			return
+		}
+
+		err = writeSSE(w, "message", buff.Bytes())
+		if err != nil {
+			slog.Debug("could not write sse message", logAttrs, "err", err)
+			return
+		}
+		flusher.Flush()
+	}
+
+	update := func() {
+		send(func(ctx context.Context, w io.Writer) error {
+			return renderPokerContent(ctx, w, session, user.ID)
+		})
+	}
+
+	session.UpdateUser(user.ID, func(user *models.User) { user.Active = true })
+	go session.SendUpdates()
+
+	update()
+
+	// Proxies tend to close connections that are quiet for too long
+	keepAlive := time.NewTicker(time.Second * 30)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-user.UpdateCh:
+			update()
+		case <-user.Closed():
+			send(components.PokerError("Your connection has been forcibly closed. Redirecting...", fmt.Sprintf("/session/%s", session.ID)).Render)
+			return
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			if err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handleUserAction applies the commands posted by the session room when it uses server sent events
+func handleUserAction(w http.ResponseWriter, r *http.Request) {
+	session := sessionManager.Get(r.PathValue("sessionID"))
+	if session == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	user, ok := session.User(r.PathValue("userID"))
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if callerID, ok := requestUserID(r, session); !ok || callerID != user.ID {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	logAttrs := slog.Group("", slog.String("session", session.ID), slog.String("user", user.Name))
+
+	renderError := func(message string) {
+		err := components.PokerError(message, "").Render(r.Context(), w)
+		if err != nil {
+			slog.Error("could not render poker error", logAttrs, "err", err)
+		}
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		slog.Error("could not read action body", logAttrs, "err", err)
+		renderError("An error occured while retrieving data")
+		return
+	}
+
+	command, err := parseCommand(body)
+	if err != nil {
+		slog.Error("could not parse command", logAttrs, "err", err)
+		renderError("An error occured while retrieving data")
+		return
+	}
+
+	_, err = session.Apply(user.ID, command)
+	if err != nil {
+		slog.Debug("could not apply command", logAttrs, "err", err)
+		renderError(err.Error())
+		return
+	}
+
+	session.SendUpdates()
+	renderError("")
+}
@@ -7,4 +7,42 @@ function copyContent(element) {
     let oldTooltip = element.attributes["data-tooltip"].value;
     element.attributes["data-tooltip"].value = "Copied!";
     setTimeout(() => { element.attributes["data-tooltip"].value = oldTooltip }, 5000);
-}
\ No newline at end of file
+}
+
+document.addEventListener("htmx:wsOpen", (event) => {
+    event.target.dataset.wsOpened = "true";
+});
+document.addEventListener("htmx:wsClose", (event) => fallbackToSSE(event.target));
+document.addEventListener("htmx:wsError", (event) => fallbackToSSE(event.target));
+
+/**
+ * Reload the session room with server sent events if the websocket never connected,
+ * which happens when a proxy strips the websocket upgrade.
+ * @param {HTMLElement} element
+ */
+function fallbackToSSE(element) {
+    let url = element.dataset.sseFallback;
+    if (!url || element.dataset.wsOpened) {
+        return;
+    }
+
+    delete element.dataset.sseFallback;
+    htmx.ajax("GET", url, { target: "#main" });
+}
+
+/**
+ * Count down the round timers. The server sends the remaining time every time the room is rendered,
+ * so the end is worked out on the first tick to avoid depending on the clocks matching.
+ */
+function tickTimers() {
+    for (let element of document.querySelectorAll("[data-timer-remaining]")) {
+        if (!element.dataset.timerEnds) {
+            element.dataset.timerEnds = Date.now() + Number(element.dataset.timerRemaining);
+        }
+
+        let seconds = Math.max(0, Math.ceil((Number(element.dataset.timerEnds) - Date.now()) / 1000));
+        element.innerText = `${Math.floor(seconds / 60)}:${String(seconds % 60).padStart(2, "0")}`;
+    }
+}
+
+setInterval(tickTimers, 250);
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/joeyak/scrum-poker/models"
+)
+
+// SessionStore is where the SessionManager keeps its sessions
+type SessionStore interface {
+	Get(ID string) *models.Session
+	Put(session *models.Session) error
+	Delete(IDs ...string) error
+	All() []*models.Session
+	// Close saves anything that hasn't been saved yet
+	Close() error
+}
+
+type MemoryStore struct {
+	mu sync.RWMutex
+	m  map[string]*models.Session
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{m: map[string]*models.Session{}}
+}
+
+func (store *MemoryStore) Get(ID string) *models.Session {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.m[ID]
+}
+
+func (store *MemoryStore) Put(session *models.Session) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.m[session.ID] = session
+	return nil
+}
+
+func (store *MemoryStore) Delete(IDs ...string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for _, ID := range IDs {
+		delete(store.m, ID)
+	}
+	return nil
+}
+
+func (store *MemoryStore) All() []*models.Session {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	var sessions []*models.Session
+	for _, session := range store.m {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+func (store *MemoryStore) Close() error {
+	return nil
+}
+
+// fileStoreDelay is how long changes are collected before the store file is written,
+// so a burst of card clicks is one write instead of one for each click
+const fileStoreDelay = time.Second
+
+// FileStore keeps sessions in memory and writes a JSON snapshot of all of them to a file.
+// Changes are written together after fileStoreDelay, and Close writes any that are still waiting.
+type FileStore struct {
+	*MemoryStore
+	path    string
+	delay   time.Duration
+	writeMu sync.Mutex
+
+	pendingMu sync.Mutex
+	pending   *time.Timer
+}
+
+func NewFileStore(path string) (*FileStore, error) {
+	store := &FileStore{MemoryStore: NewMemoryStore(), path: path, delay: fileStoreDelay}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read store file: %w", err)
+	}
+
+	var sessions map[string]*models.Session
+	err = json.Unmarshal(data, &sessions)
+	if err != nil {
+		return nil, fmt.Errorf("could not unmarshal store file: %w", err)
+	}
+
+	for ID, session := range sessions {
+		session.Restore()
+		store.m[ID] = session
+	}
+
+	return store, nil
+}
+
+func (store *FileStore) Put(session *models.Session) error {
+	store.MemoryStore.Put(session)
+	store.schedule()
+	return nil
+}
+
+func (store *FileStore) Delete(IDs ...string) error {
+	store.MemoryStore.Delete(IDs...)
+	store.schedule()
+	return nil
+}
+
+// schedule writes the store file after the delay, unless a write is already waiting which will pick up the change
+func (store *FileStore) schedule() {
+	store.pendingMu.Lock()
+	defer store.pendingMu.Unlock()
+
+	if store.pending != nil {
+		return
+	}
+	store.pending = time.AfterFunc(store.delay, func() {
+		err := store.Flush()
+		if err != nil {
+			slog.Error("could not write store file", "file", store.path, "err", err)
+		}
+	})
+}
+
+// Flush writes the store file now if there are changes waiting to be written
+func (store *FileStore) Flush() error {
+	store.pendingMu.Lock()
+	pending := store.pending != nil
+	if pending {
+		store.pending.Stop()
+		store.pending = nil
+	}
+	store.pendingMu.Unlock()
+
+	if !pending {
+		return nil
+	}
+	return store.write()
+}
+
+func (store *FileStore) Close() error {
+	return store.Flush()
+}
+
+func (store *FileStore) write() error {
+	store.writeMu.Lock()
+	defer store.writeMu.Unlock()
+
+	sessions := map[string]*models.Session{}
+	for _, session := range store.All() {
+		sessions[session.ID] = session
+	}
+
+	data, err := json.Marshal(sessions)
+	if err != nil {
+		return fmt.Errorf("could not marshal sessions: %w", err)
+	}
+
+	// Write to a temp file first so a crash mid write doesn't corrupt the store
+	tmp, err := os.CreateTemp(filepath.Dir(store.path), filepath.Base(store.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not create temp store file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write temp store file: %w", err)
+	}
+
+	// Flush to disk before the rename so a crash can't leave the store file empty or partly written
+	err = tmp.Sync()
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not sync temp store file: %w", err)
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("could not close temp store file: %w", err)
+	}
+
+	err = os.Rename(tmp.Name(), store.path)
+	if err != nil {
+		return fmt.Errorf("could not replace store file: %w", err)
+	}
+
+	return nil
+}
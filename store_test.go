@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/joeyak/scrum-poker/models"
+)
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := NewSessionManager(store)
+
+	session, err := manager.New(models.NewSessionInfo([]string{"1", "2", "3"}, []string{"Frontend", "Backend"}, nil, true), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := session.NewUser("Ada", models.UserTypeParticipant, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, command := range []models.Command{
+		models.SelectCard{Row: "Frontend", Card: "3"},
+		models.AddStory{Story: models.Story{Title: "Login"}},
+	} {
+		if _, err := session.Apply(user.ID, command); err != nil {
+			t.Fatal(err)
+		}
+		session.SendUpdates()
+	}
+
+	if _, err := os.Stat(path); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("store file was written before the delay: %v", err)
+	}
+	if err := manager.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := loaded.Get(session.ID)
+	if restored == nil {
+		t.Fatal("session was not loaded")
+	}
+
+	snapshot := restored.Snapshot()
+	if !snapshot.Expires.Equal(session.Expires) || !slices.Equal(snapshot.Rows, []string{"Frontend", "Backend"}) {
+		t.Errorf("session is %+v, wanted it to match the saved one", snapshot.SessionInfo)
+	}
+	if len(snapshot.Stories) != 1 || snapshot.Stories[0].Title != "Login" {
+		t.Errorf("stories are %+v, wanted the saved story", snapshot.Stories)
+	}
+	restoredUser := snapshot.Users[user.ID]
+	if restoredUser == nil || restoredUser.Cards["Frontend"] != "3" || !restoredUser.Facilitator || restoredUser.Active {
+		t.Fatalf("user is %+v, wanted the saved facilitator with their card and not active", restoredUser)
+	}
+
+	// Restore sets up the lock and channels so the session can be used again
+	if _, err := restored.Apply(user.ID, models.SelectCard{Row: "Backend", Card: "2"}); err != nil {
+		t.Errorf("could not vote in the restored session: %v", err)
+	}
+	if _, err := restored.NewUser("Grace", models.UserTypeWatcher, "", ""); err != nil {
+		t.Errorf("could not join the restored session: %v", err)
+	}
+}
+
+func TestFileStoreCleanup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := NewSessionManager(store)
+	info := models.NewSessionInfo([]string{"1", "2", "3"}, nil, nil, true)
+
+	var live *models.Session
+	for i := range 3 {
+		session, err := manager.New(info, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			live = session
+		} else {
+			session.Expires = time.Now().Add(-time.Minute)
+		}
+	}
+	if err := manager.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	manager.Cleanup()
+	if err := manager.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := loaded.All()
+	if len(sessions) != 1 || sessions[0].ID != live.ID {
+		t.Errorf("store file has %d sessions, wanted only the live one", len(sessions))
+	}
+}
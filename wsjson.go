@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+
+	"github.com/coder/websocket"
+	"github.com/joeyak/scrum-poker/models"
+)
+
+// jsonSubprotocol is negotiated by clients that want json instead of the html used by htmx
+const jsonSubprotocol = "scrum-poker.v1+json"
+
+type wsJsonMessageType string
+
+var (
+	wsJsonMessageState wsJsonMessageType = "state"
+	wsJsonMessageEvent wsJsonMessageType = "event"
+	wsJsonMessageError wsJsonMessageType = "error"
+)
+
+type wsJsonMessage struct {
+	Type  wsJsonMessageType
+	State *apiSession   `json:",omitempty"`
+	Event *models.Event `json:",omitempty"`
+	Error string        `json:",omitempty"`
+}
+
+// serveUserWsJson sends the session events and state as json and takes commands parsed with models.ParseCommand
+func serveUserWsJson(ctx context.Context, conn *websocket.Conn, session *models.Session, user models.User, logAttrs slog.Attr) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	write := func(message wsJsonMessage) {
+		data, err := json.Marshal(message)
+		if err != nil {
+			slog.Error("could not marshal json websocket message", logAttrs, "err", err)
+			return
+		}
+
+		err = conn.Write(ctx, websocket.MessageText, data)
+		if err != nil {
+			slog.Debug("could not write json websocket message", logAttrs, "err", err)
+		}
+	}
+
+	go func() {
+		for {
+			_, message, err := conn.Read(ctx)
+			if err != nil {
+				if !errors.As(err, &websocket.CloseError{}) && !errors.Is(err, io.EOF) {
+					slog.Debug("could not read connection", logAttrs, "err", err)
+				}
+				cancel()
+				return
+			}
+
+			command, err := models.ParseCommand(message)
+			if err != nil {
+				write(wsJsonMessage{Type: wsJsonMessageError, Error: err.Error()})
+				continue
+			}
+
+			_, err = session.Apply(user.ID, command)
+			if err != nil {
+				write(wsJsonMessage{Type: wsJsonMessageError, Error: err.Error()})
+				continue
+			}
+
+			session.SendUpdates()
+		}
+	}()
+
+	lastSeq := session.LastEventSeq()
+	writeEvents := func() {
+		for _, event := range session.EventsSince(lastSeq) {
+			write(wsJsonMessage{Type: wsJsonMessageEvent, Event: &event})
+			lastSeq = event.Seq
+		}
+	}
+
+	update := func() {
+		writeEvents()
+		state := newApiSession(session)
+		write(wsJsonMessage{Type: wsJsonMessageState, State: &state})
+	}
+
+	update()
+
+	for {
+		select {
+		case <-user.UpdateCh:
+			update()
+		case <-user.Closed():
+			writeEvents()
+			conn.Close(websocket.StatusNormalClosure, "removed from session")
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}